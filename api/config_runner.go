@@ -7,5 +7,12 @@ import (
 
 // Run gmeter from programmatic api instead of command line
 func Run(options *config.GOptions) error {
+	_, err := meter.Execute(options)
+	return err
+}
+
+// RunWithResult runs gmeter like Run, and returns result of all configs executed,
+// which is always valid even if error is returned.
+func RunWithResult(options *config.GOptions) (*config.Result, error) {
 	return meter.Execute(options)
 }
@@ -33,31 +33,266 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// TLS defines how gmeter verifies an https server and how it authenticates itself
+// to server if mutual TLS is required.
+//
+// File paths, if relative, are relative to config file path.
+type TLS struct {
+	// CA is a PEM file containing CA certificate(s) used to verify server certificate.
+	// If empty, system root CAs are used.
+	CA string
+	// Cert and Key are PEM files of client certificate and its private key, they are
+	// required only if server requires mutual TLS and must be present together.
+	Cert string
+	Key  string
+	// ServerName overrides the server name used for SNI and certificate verification.
+	// If empty, domain of Host is used.
+	ServerName string
+	// InsecureSkipVerify set to true to accept any certificate server presents.
+	// It should be used for test only.
+	InsecureSkipVerify bool
+}
+
+// Check validates TLS setting.
+func (t *TLS) Check() error {
+	if (len(t.Cert) == 0) != (len(t.Key) == 0) {
+		return fmt.Errorf("client certificate and key must be present together")
+	}
+	return nil
+}
+
+// Transport tunes how connections to a host are made and reused.
+//
+// By default all routines of a test share a connection pool keeping at most 2
+// idle connections to host, so a benchmark with more routines opens and closes
+// connections frequently. MaxIdleConns should be at least the concurrency to
+// reuse connections, and PerRoutine simulates independent clients.
+type Transport struct {
+	// MaxIdleConns is max idle keep-alive connections kept to host, default 2.
+	MaxIdleConns int
+	// IdleTimeout is how long an idle connection is kept, like "30s", default "90s".
+	IdleTimeout string
+	// DisableKeepAlive sends "Connection: close" so that connection is closed after each response.
+	DisableKeepAlive bool
+	// NewConnection opens a new connection for each request and closes it after
+	// response, while keep-alive is still announced to server. HTTP/1.1 is used
+	// since HTTP/2 sends all requests on a single connection.
+	NewConnection bool
+	// PerRoutine makes each routine use a dedicated connection pool, so that N
+	// routines really open N connections.
+	PerRoutine bool
+}
+
+// Check validates Transport setting.
+func (t *Transport) Check() error {
+	if t.MaxIdleConns < 0 {
+		return fmt.Errorf("negative max idle connections %d", t.MaxIdleConns)
+	}
+	if len(t.IdleTimeout) > 0 {
+		if _, err := time.ParseDuration(t.IdleTimeout); err != nil {
+			return fmt.Errorf("invalid idle timeout %s: %v", t.IdleTimeout, err)
+		}
+	}
+	return nil
+}
+
 // Host defines a server and proxy to visit this server
 type Host struct {
-	// format: http://domain[:port][/more[/more...]], https is not supported yet.
+	// format: http[s]://domain[:port][/more[/more...]]
+	// or unix:///path/to.sock for a server listening on unix domain socket, requests
+	// are sent as http://localhost/<path>. Relative socket path like unix://app.sock
+	// is relative to config file path.
+	// or tcp://domain:port and udp://domain:port for a socket test, see Test.Socket.
 	Host string
 	// Proxy defines a proxy used to access Host.
 	// format: <protocol>://[user:password@]domain[:port], protocol could be http or socks5
 	Proxy string
+	// TLS defines TLS setting for an https Host, optional.
+	TLS *TLS
+	// Transport tunes connections to Host, optional.
+	Transport *Transport
+	// Backends, if defined, lists servers requests are balanced among, and Host is
+	// ignored. All backends share other settings of this host.
+	Backends []*Backend
+	// Balance defines how a backend is chosen for a request, it could be:
+	//   - "round-robin" or "": choose backends in turn
+	//   - "random": choose a backend randomly
+	//   - "weighted": choose a backend randomly by Backend.Weight
+	//   - "least-in-flight": choose the backend with least requests in flight
+	Balance string
+	// Eject is a cool-down like "10s", a backend is not chosen in this period after
+	// HTTP execution on it fails or it responds a 5xx status. Default no ejection.
+	// If all backends are ejected, the one whose cool-down ends first is chosen.
+	Eject string
+	// Resolve maps "domain:port" to a fixed address like "127.0.0.1" or "127.0.0.1:8443",
+	// just like curl --resolve. Connections to domain:port go to that address, while
+	// Host header and TLS server name still use domain.
+	Resolve map[string]string
+	// Redirect defines how redirect responses are handled, it could be:
+	//   - "follow" or "": follow at most 10 redirects, like a browser does
+	//   - "none": do not follow, the redirect response is processed as it is
+	//   - a number like "3": follow at most 3 redirects
+	// Request fails if more redirects than allowed are met.
+	// Test.Redirect is preferred if both are defined.
+	Redirect string
+	// Auth authenticates requests to this host, Test.Auth is preferred if both
+	// are defined.
+	Auth *Auth
 }
 
-// Check validates Host setting.
-func (h *Host) Check() error {
-	matched, matchErr := regexp.Match("^http://([^@:]+:[^@:]+@)?.*(:[0-9]+)?(/[^?&]+)*$", []byte(h.Host))
+// Backend is a server of a Host balancing requests among several servers.
+type Backend struct {
+	Host   string // format: http[s]://domain[:port][/more[/more...]]
+	Weight int    // weight for "weighted" balance, default 1
+}
+
+// balance policies, see Host.Balance
+const (
+	BalanceRoundRobin    = "round-robin"
+	BalanceRandom        = "random"
+	BalanceWeighted      = "weighted"
+	BalanceLeastInFlight = "least-in-flight"
+)
+
+// checkURL validates an http or https host URL
+func checkURL(host string) error {
+	matched, matchErr := regexp.Match("^https?://([^@:]+:[^@:]+@)?.*(:[0-9]+)?(/[^?&]+)*$", []byte(host))
 	if matchErr != nil {
 		panic(fmt.Sprintf("http match regexp fail, error: %v", matchErr))
 	}
 	if !matched {
-		return fmt.Errorf("host invalid: %s", h.Host)
+		return fmt.Errorf("host invalid: %s", host)
 	}
+	return nil
+}
+
+// UnixPrefix is prefix of a Host listening on unix domain socket
+const UnixPrefix = "unix://"
 
+// prefixes of a Host for socket test
+const (
+	TCPPrefix = "tcp://"
+	UDPPrefix = "udp://"
+)
+
+// IsSocketHost tells if host is a tcp or udp host for socket test
+func IsSocketHost(host string) bool {
+	return strings.HasPrefix(host, TCPPrefix) || strings.HasPrefix(host, UDPPrefix)
+}
+
+// Check validates Host setting.
+func (h *Host) Check() error {
+	if h.Transport != nil {
+		if err := h.Transport.Check(); err != nil {
+			return fmt.Errorf("host %s: %v", h.Host, err)
+		}
+	}
+
+	if err := CheckRedirect(h.Redirect); err != nil {
+		return fmt.Errorf("host %s: %v", h.Host, err)
+	}
+	if h.Auth != nil {
+		if err := h.Auth.Check(); err != nil {
+			return fmt.Errorf("host %s: %v", h.Host, err)
+		}
+	}
+
+	if strings.HasPrefix(h.Host, UnixPrefix) {
+		if len(h.Host) == len(UnixPrefix) {
+			return fmt.Errorf("socket path missing: %s", h.Host)
+		}
+		if len(h.Proxy) > 0 || h.TLS != nil || len(h.Resolve) > 0 || len(h.Backends) > 0 {
+			return fmt.Errorf("proxy, TLS, resolve or backends is defined for unix socket %s", h.Host)
+		}
+		return nil
+	}
+	if IsSocketHost(h.Host) {
+		if _, _, err := net.SplitHostPort(h.Host[len(TCPPrefix):]); err != nil {
+			return fmt.Errorf("socket host %s invalid: %v", h.Host, err)
+		}
+		if len(h.Proxy) > 0 || h.TLS != nil || len(h.Resolve) > 0 || len(h.Backends) > 0 {
+			return fmt.Errorf("proxy, TLS, resolve or backends is defined for socket host %s", h.Host)
+		}
+		return nil
+	}
+
+	urls := []string{h.Host}
+	if len(h.Backends) > 0 {
+		urls = nil
+		for i, b := range h.Backends {
+			if b == nil {
+				return fmt.Errorf("backend %d not defined", i)
+			}
+			if b.Weight < 0 {
+				return fmt.Errorf("backend %s negative weight %d", b.Host, b.Weight)
+			}
+			urls = append(urls, b.Host)
+		}
+		switch h.Balance {
+		case "", BalanceRoundRobin, BalanceRandom, BalanceWeighted, BalanceLeastInFlight:
+		default:
+			return fmt.Errorf("unknown balance %s", h.Balance)
+		}
+		if len(h.Eject) > 0 {
+			if _, err := time.ParseDuration(h.Eject); err != nil {
+				return fmt.Errorf("invalid eject %s: %v", h.Eject, err)
+			}
+		}
+	}
+	for _, u := range urls {
+		if err := checkURL(u); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range h.Resolve {
+		if _, _, err := net.SplitHostPort(k); err != nil {
+			return fmt.Errorf("host %s resolve %s: %v", h.Host, k, err)
+		}
+		if len(v) == 0 {
+			return fmt.Errorf("host %s resolve %s to empty address", h.Host, k)
+		}
+	}
+
+	if h.TLS != nil {
+		for _, u := range urls {
+			if !strings.HasPrefix(u, "https://") {
+				return fmt.Errorf("TLS is defined for non-https host %s", u)
+			}
+		}
+		if err := h.TLS.Check(); err != nil {
+			return fmt.Errorf("host %s: %v", h.Host, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckRedirect validates redirect policy, see Host.Redirect.
+func CheckRedirect(r string) error {
+	switch r {
+	case "", RedirectFollow, RedirectNone:
+		return nil
+	}
+	if n, err := strconv.Atoi(r); err != nil || n < 0 {
+		return fmt.Errorf("invalid redirect policy %s", r)
+	}
 	return nil
 }
 
+// redirect policies
+const (
+	RedirectFollow = "follow"
+	RedirectNone   = "none"
+)
+
 // Test defines parameters required to execute an HTTP request.
 //
 // gmeter will first call PreProcess if defined any, then use Host and RequestMessage
@@ -84,7 +319,36 @@ type Test struct {
 	// HTTP request timeout, like "5s", "1m10s", "30ms"...
 	// If Timeout is empty, try use  Schedule.Env["TIMEOUT"] as default value;
 	// if it's still empty, it'll be set to "1m" as default value
-	Timeout  string
+	Timeout string
+	// QPS specifies max request of this test at a single second, see Schedule.QPS.
+	// It applies together with Schedule.QPS, 0 to disable.
+	QPS float64
+	// Burst specifies burst requests of this test while QPS is enabled, default 1.
+	Burst int
+	// Redirect defines redirect policy of this test, see Host.Redirect.
+	Redirect string
+	// Retry defines how a failed request is retried. If it's not defined, request
+	// is retried once immediately if HTTP execution fails.
+	Retry *Retry
+	// Compress compresses request body by "gzip" or "deflate", and sends it with
+	// Content-Encoding header.
+	Compress string
+	// Decompress sends "Accept-Encoding: gzip, deflate" if request does not define
+	// one, and decompresses response by its Content-Encoding before it is processed.
+	// If false, response is processed as it is on wire.
+	Decompress bool
+	// WebSocket, if defined, makes this test a WebSocket test, Request and
+	// RequestMessage are not used.
+	WebSocket *WebSocket
+	// Socket, if defined, makes this test a raw TCP or UDP test on a socket host,
+	// Request and RequestMessage are not used.
+	Socket *Socket
+	// ThinkTime defines a pause after this test succeeds, before next test of
+	// pipeline runs, or next iteration starts if it's the last one.
+	ThinkTime *ThinkTime
+	// Auth authenticates requests of this test, see Auth.
+	Auth *Auth
+
 	imported bool
 }
 
@@ -96,6 +360,236 @@ func (t *Test) SetImported() {
 	t.imported = true
 }
 
+// Retry defines retry policy of a test.
+//
+// A request is retried if it fails with a failure class in Classes, or server
+// responds a status code in Status, until it succeeds or Attempts is reached.
+// Only failures before response processing could be retried, they are:
+// conn_refused, timeout, dns, tls, request and read_body, see PerfStat. Response
+// processing like Check and Template is called only for the last attempt, so a
+// Check failure never causes a retry, and $(ATTEMPT) tells which attempt it is,
+// starting from 1.
+//
+// Retry replaces the default policy retrying once for any HTTP execution failure,
+// a failure of a class not in Classes is not retried.
+//
+// Before each retry, gmeter waits for a backoff, which starts from Backoff and
+// doubles after each retry until MaxBackoff, and is randomized by Jitter.
+type Retry struct {
+	Attempts int      // max attempts including the first one, must be positive
+	Status   []int    // status codes to retry, like 429, 502, 503
+	Classes  []string // failure classes to retry, like "timeout", "conn_refused"
+	// Backoff is duration before the first retry, like "100ms", default to be 0
+	// which means retry immediately.
+	Backoff string
+	// MaxBackoff limits backoff, like "5s", default no limit.
+	MaxBackoff string
+	// Jitter is in [0, 1], a backoff d will be randomized in [d*(1-Jitter), d*(1+Jitter)].
+	Jitter float64
+	// CountRetries tells if retried attempts are counted in statistics, including
+	// requests, errors and latency. By default only the last attempt is counted.
+	CountRetries bool
+}
+
+// Check validates retry policy.
+func (r *Retry) Check() error {
+	if r.Attempts <= 0 {
+		return fmt.Errorf("retry attempts %d must be positive", r.Attempts)
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("retry jitter %v not in [0, 1]", r.Jitter)
+	}
+	for _, c := range r.Classes {
+		switch c {
+		case "conn_refused", "timeout", "dns", "tls", "request", "read_body":
+		default:
+			return fmt.Errorf("failure class %s can not be retried", c)
+		}
+	}
+	for _, d := range []string{r.Backoff, r.MaxBackoff} {
+		if len(d) > 0 {
+			if _, err := time.ParseDuration(d); err != nil {
+				return fmt.Errorf("invalid retry backoff %s: %v", d, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WebSocket defines a WebSocket test.
+//
+// The test connects to URL composed of Test.Host and Path, whose scheme http and
+// https is replaced by ws and wss, and runs Exchanges in order on the connection.
+// Each exchange sends a message and waits for a reply, which is written to
+// $(RESPONSE) and processed by Template and Check of the exchange, and the round
+// trip is counted as a request in statistics. If all exchanges succeed, the last
+// reply is processed by Test.Response.
+//
+// Test.Timeout limits handshake and each wait for a reply.
+type WebSocket struct {
+	Path      string            // [dynamic] path of URL like "/chat?room=1"
+	Headers   map[string]string // [dynamic] headers of handshake request
+	Exchanges []*Exchange       // exchanges in running order
+}
+
+// Exchange sends a message and waits for a reply on a WebSocket connection.
+type Exchange struct {
+	Send     string          // [dynamic] message to send, empty to only wait for a reply
+	Binary   bool            // send as binary message instead of text
+	NoReply  bool            // do not wait for a reply
+	Check    interface{}     // [dynamic] segments called with reply in $(RESPONSE)
+	Template json.RawMessage // [dynamic] json compare template to compare with reply
+}
+
+// Check validates WebSocket test.
+func (w *WebSocket) Check() error {
+	if len(w.Exchanges) == 0 {
+		return fmt.Errorf("no WebSocket exchanges defined")
+	}
+	for i, e := range w.Exchanges {
+		if e == nil {
+			return fmt.Errorf("exchange %d not defined", i)
+		}
+		if e.NoReply && (len(e.Send) == 0 || e.Check != nil || len(e.Template) > 0) {
+			return fmt.Errorf("exchange %d sends nothing or checks a reply not waited", i)
+		}
+	}
+	return nil
+}
+
+// Auth defines how HTTP requests are authenticated. Basic and Token are
+// exclusive, while Sign could be used with either of them.
+type Auth struct {
+	Basic *BasicAuth // HTTP basic authentication
+	Token *Token     // bearer token got by a token test
+	Sign  *Sign      // HMAC request signing
+}
+
+// BasicAuth sends "Authorization: Basic <base64 of User:Password>".
+type BasicAuth struct {
+	User     string // [dynamic] user name
+	Password string // [dynamic] password
+}
+
+// Token defines a bearer token got by running test Test.
+//
+// Token is cached in db at Key, and its expiry at "<Key>.EXPIRES" as unix time
+// in milliseconds. Before a request is composed, a cached token is used if it
+// does not expire, otherwise Test is run to get a new one. If server responds
+// 401, the token is dropped, and the request is sent once more with a new one.
+// The token sent is written to $(TOKEN).
+//
+// Test runs with local variables of the test requesting token, and is not
+// counted in statistics. Once it succeeds, Value is called with its response
+// in $(RESPONSE) to get the token.
+type Token struct {
+	Test  string // name of token test in Config.Tests
+	Value string // [dynamic] token, like "`json .access_token $(RESPONSE)`"
+	// [dynamic] lifetime of token, seconds like "3600" or duration like "1h". Token
+	// never expires if it's empty, or not defined.
+	ExpiresIn string
+	Key       string // db key of token, default "TOKEN.<Test>"
+	// Header carrying token, default "Authorization" with value "Bearer <token>",
+	// token is sent as it is in other headers.
+	Header string
+}
+
+// Sign signs requests by HMAC. After request body is composed, signature is
+// calculated by Secret over:
+//     <method>\n<path and query>\n<timestamp>\n<body>
+// where timestamp is unix time in seconds, and body is the one sent on wire,
+// which is compressed if Test.Compress is defined. Signature is sent in Header,
+// and timestamp in TimestampHeader.
+type Sign struct {
+	Secret          string // [dynamic] HMAC key
+	Algorithm       string // "sha256" or "", "sha1", "sha512"
+	Encoding        string // encoding of signature, "hex" or "", "base64"
+	Header          string // signature header, default "X-Signature"
+	TimestampHeader string // timestamp header, default "X-Timestamp"
+}
+
+// Check validates auth setting.
+func (a *Auth) Check() error {
+	if a.Basic != nil && a.Token != nil {
+		return fmt.Errorf("basic auth and token are both defined")
+	}
+	if a.Basic != nil && len(a.Basic.User) == 0 {
+		return fmt.Errorf("basic auth user not defined")
+	}
+	if t := a.Token; t != nil && (len(t.Test) == 0 || len(t.Value) == 0) {
+		return fmt.Errorf("token test or value not defined")
+	}
+	if s := a.Sign; s != nil {
+		if len(s.Secret) == 0 {
+			return fmt.Errorf("sign secret not defined")
+		}
+		switch s.Algorithm {
+		case "", "sha1", "sha256", "sha512":
+		default:
+			return fmt.Errorf("unknown sign algorithm %s", s.Algorithm)
+		}
+		switch s.Encoding {
+		case "", "hex", "base64":
+		default:
+			return fmt.Errorf("unknown sign encoding %s", s.Encoding)
+		}
+	}
+	return nil
+}
+
+// framing of socket response, see Socket.Framing
+const (
+	FramingDelimiter = "delimiter"
+	FramingLength    = "length"
+	FramingTimeout   = "timeout"
+)
+
+// Socket defines a raw TCP or UDP test.
+//
+// For each request, the test connects to Test.Host like "tcp://127.0.0.1:9000"
+// or "udp://127.0.0.1:9000", sends Send which is also written to $(REQUEST),
+// reads a response into $(RESPONSE), and closes the connection. Then response is
+// processed by Test.Response as an HTTP response except that $(STATUS) is empty.
+//
+// A TCP response is framed by Framing, which could be:
+//   - "delimiter" or "": response ends with Delimiter, which is not included in
+//     $(RESPONSE). Delimiter is appended to Send if Send does not end with it.
+//   - "length": response starts with a big-endian length of LengthBytes bytes,
+//     which is not included in $(RESPONSE). Send is prefixed with its length too.
+//   - "timeout": response ends if no more data arrives in ReadTimeout after its
+//     first byte, or server closes connection. First byte is waited till Timeout.
+// A UDP response is always a single datagram, Framing is not used.
+//
+// Test.Timeout limits the whole request including connecting and reading.
+type Socket struct {
+	Send        string // [dynamic] payload to send
+	NoReply     bool   // do not wait for a response
+	Framing     string // how TCP response is framed
+	Delimiter   string // delimiter of "delimiter" framing, default "\n"
+	LengthBytes int    // length size of "length" framing, could be 1, 2 or 4, default 4
+	ReadTimeout string // idle period ending a response of "timeout" framing, default "100ms"
+}
+
+// Check validates socket test.
+func (s *Socket) Check() error {
+	switch s.Framing {
+	case "", FramingDelimiter, FramingLength, FramingTimeout:
+	default:
+		return fmt.Errorf("unknown framing %s", s.Framing)
+	}
+	switch s.LengthBytes {
+	case 0, 1, 2, 4:
+	default:
+		return fmt.Errorf("length bytes %d not 1, 2 or 4", s.LengthBytes)
+	}
+	if len(s.ReadTimeout) > 0 {
+		if _, err := time.ParseDuration(s.ReadTimeout); err != nil {
+			return fmt.Errorf("invalid read timeout %s: %v", s.ReadTimeout, err)
+		}
+	}
+	return nil
+}
+
 // Option defines options gmeter accepts. These options can be used as key in Config.Options.
 type Option string
 
@@ -111,6 +605,11 @@ const (
 	// internal usage. "true" or "false", default "false".
 	// set to true to enable gmeter dumping.
 	OptionDebug Option = "Debug" // true or false
+
+	// "true" or "false", default "false"
+	// If set to true, each routine keeps cookies in a jar across tests of an iteration,
+	// and jar is reset when next iteration starts.
+	OptionCookieJar Option = "CookieJar"
 )
 
 // Report allows test write customized content into given file.
@@ -213,9 +712,20 @@ type Schedule struct {
 	//   3. "*" will execute t1 ~ t7 in random sequence once for each
 	//   4. "*|t2|t3" will execute t1, t4~t7 in random sequence, and then execute t2, t3
 	//   5. "t2|t3|*" will execute t2, t3, and then run t1, t4~t7 in random sequence
-	//   6. "" is invalid(no case)
+	//   6. "" is invalid(no case) unless Mix is defined
 	Tests string
 
+	// Mix, if defined, makes a weighted traffic mix instead of Tests, they are
+	// exclusive. For each iteration of each routine, one of Mix is picked randomly
+	// by weight and run, and Count limits iterations of all routines. The observed
+	// ratio of each is reported after schedule ends. For example, 70% search, 25% detail and 5% "cart|checkout":
+	//     "Mix": [
+	//         { "Tests": "search", "Weight": 70 },
+	//         { "Tests": "detail", "Weight": 25 },
+	//         { "Tests": "cart|checkout", "Weight": 5 }
+	//     ]
+	Mix []*Mix
+
 	// TestBase is a special test that behavior like a super class of Tests, this is how
 	// it works:
 	//
@@ -241,18 +751,155 @@ type Schedule struct {
 	// 0 or 1 for one routine, or specified routines, default: 1 routine
 	Concurrency int
 
-	// QPS specifies max request at a single second.
-	// Set to a value greater than 1 to enable it.
-	QPS int
+	// QPS specifies max request at a single second, requests are paced evenly.
+	// Set to a value greater than 0 to enable it, fractional value like 0.5
+	// is allowed.
+	QPS float64
+
+	// Burst specifies how many requests could be sent at once without pacing
+	// while QPS is enabled, default 1.
+	Burst int
 
 	// Max executing HTTP request. Effective only if Concurrency greater than 1.
 	// Set to a value greater than Concurrency to enable it.
 	Parallel int
 
+	// Duration defines how long this schedule runs, like "30s", "10m", "1h30m".
+	// Schedule finishes when Duration elapses even Count is not reached or iterable
+	// test does not reach EOF. Empty for no time limit.
+	Duration string
+
+	// Stages defines a load profile that changes concurrency and/or QPS while
+	// schedule runs. Stages are executed one by one, each stage changes concurrency
+	// and QPS linearly from where previous stage ends(or Concurrency and QPS for the
+	// first stage) to its targets in its Duration. Schedule finishes after last stage
+	// ends. For example, this ramps up from 10 to 200 routines in 5 minutes, holds
+	// for 30 minutes, and ramps down:
+	//     "Concurrency": 10,
+	//     "Stages": [
+	//         { "Duration": "5m", "Concurrency": 200 },
+	//         { "Duration": "30m" },
+	//         { "Duration": "5m", "Concurrency": 1 }
+	//     ]
+	Stages []*Stage
+
+	// Arrival, if defined, runs Tests in an open-loop model: a pipeline of Tests is
+	// started following arrival rate no matter how many pipelines are in flight,
+	// so that a slow server can not lower offered load. Concurrency and Stages
+	// are ignored in this model, so are ThinkTime and Pacing.
+	Arrival *Arrival
+
+	// ThinkTime defines a pause of each routine between its iterations.
+	ThinkTime *ThinkTime
+
+	// Pacing like "2s" makes each routine start an iteration every Pacing. It is
+	// applied after ThinkTime, and if an iteration lasts longer than Pacing, next
+	// one starts at once.
+	Pacing string
+
+	// PerfReport defines a file path to write latency statistics in json after
+	// schedule ends, including count, QPS, max/min/avg/p50/p90/p95/p99/p99.9
+	// latency and a full latency histogram. Latency is in microseconds.
+	//
+	// If PerfReport is a relative path, it is relative to config file path.
+	PerfReport string
+
 	// Env defines predefined local environment variables.
 	Env map[string]string
 }
 
+// think time models, see ThinkTime.Model
+const (
+	ThinkFixed       = "fixed"
+	ThinkUniform     = "uniform"
+	ThinkExponential = "exponential"
+)
+
+// ThinkTime defines a random pause simulating a user, it is never counted in
+// latency of any request.
+type ThinkTime struct {
+	// Model could be:
+	//   - "fixed" or "": pause for Duration
+	//   - "uniform": pause for a random duration in [Min, Max]
+	//   - "exponential": pause for a random duration in exponential distribution
+	//     whose mean is Duration, limited by Max if it's defined
+	Model    string
+	Duration string // like "1s", "500ms"
+	Min      string
+	Max      string
+}
+
+// Check validates think time.
+func (t *ThinkTime) Check() error {
+	du := make(map[string]time.Duration)
+	for _, s := range []string{t.Duration, t.Min, t.Max} {
+		if len(s) > 0 {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				return fmt.Errorf("invalid think time %s", s)
+			}
+			du[s] = d
+		}
+	}
+	switch t.Model {
+	case "", ThinkFixed, ThinkExponential:
+		if len(t.Duration) == 0 {
+			return fmt.Errorf("think time duration not defined")
+		}
+	case ThinkUniform:
+		if len(t.Min) == 0 || len(t.Max) == 0 || du[t.Min] > du[t.Max] {
+			return fmt.Errorf("think time range [%s, %s] invalid", t.Min, t.Max)
+		}
+	default:
+		return fmt.Errorf("unknown think time model %s", t.Model)
+	}
+	return nil
+}
+
+// Mix is a test or a test pipeline of a weighted mix, see Schedule.Mix.
+type Mix struct {
+	Tests  string // a test or a test pipeline like "t1|t2", '*' is not supported
+	Weight int    // weight to be picked, default 1
+}
+
+// Stage defines a phase of a schedule's load profile, see Schedule.Stages.
+type Stage struct {
+	// Duration of this stage, like "30s", "5m", required.
+	Duration string
+	// Concurrency defines routines count at the end of this stage, 0 to keep
+	// concurrency previous stage ends with.
+	Concurrency int
+	// QPS defines QPS limit at the end of this stage, 0 to keep QPS previous stage
+	// ends with.
+	QPS float64
+}
+
+// ArrivalModel defines how arrivals distribute in time.
+type ArrivalModel string
+
+const (
+	ArrivalConstant ArrivalModel = "Constant" // arrivals with fixed interval
+	ArrivalPoisson  ArrivalModel = "Poisson"  // arrivals with exponentially distributed interval
+)
+
+// Arrival defines an open-loop load model, see Schedule.Arrival.
+//
+// While MaxInFlight pipelines are running, a new arrival is either dropped if
+// DropLate is true, or started once any pipeline ends and counted as late. Numbers
+// of started, dropped and late arrivals are written to local variables
+// `_.arrival.started`, `_.arrival.dropped` and `_.arrival.late` before
+// Schedule.PostProcess is called.
+type Arrival struct {
+	// Rate defines how many pipelines start in one second, like 0.5, 100, required.
+	Rate float64
+	// Model defines arrival distribution, default ArrivalConstant.
+	Model ArrivalModel
+	// MaxInFlight defines max pipelines running at the same time, default 1000.
+	MaxInFlight int
+	// DropLate decides whether arrivals exceeding MaxInFlight are dropped.
+	DropLate bool
+}
+
 // RunMode defines gmeter how to run several schedules.
 type RunMode string
 
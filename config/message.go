@@ -5,17 +5,73 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"time"
 )
 
 // Request defines parameters to generate an HTTP request.
 // Note that any part of any member can contain embedded commands.
 //
 // Note that Body is accepted only when it's empty, or it's a valid json.
+//
+// Body of other formats could be defined by one of Form, Multipart, Text and File
+// instead of Body, and Content-Type header is set automatically unless Headers
+// defines it.
 type Request struct {
 	Method  string            // default to be GET, could be GET/POST/PUT/DELETE
 	Path    string            // [dynamic] /path/to/target, parameter is supported like /path?param1=1&param2=hello...
-	Headers map[string]string // extra headers like "Content-Type: application/json"
+	Headers map[string]string // [dynamic] extra headers like "Content-Type: application/json"
 	Body    json.RawMessage   // [dynamic] Json body to send, or "" if no body is required.
+
+	// [dynamic] form fields sent as application/x-www-form-urlencoded body
+	Form map[string]string
+	// parts sent as multipart/form-data body
+	Multipart []*Part
+	// [dynamic] raw string body sent as text/plain
+	Text string
+	// [dynamic] path of a file whose content is sent byte-exact as application/octet-stream,
+	// relative path is relative to $(TPATH).
+	File string
+}
+
+// Part defines a part of multipart/form-data body, either Value or File should be
+// defined.
+type Part struct {
+	Name        string // form field name
+	Value       string // [dynamic] field value
+	File        string // [dynamic] path of file to upload, relative path is relative to $(TPATH)
+	ContentType string // Content-Type of file, default to be application/octet-stream
+}
+
+// hasBody checks if any body is defined, and returns error if more than one body is defined.
+func (m *Request) hasBody() (bool, error) {
+	n := 0
+	if m.Body != nil {
+		n++
+	}
+	if m.Form != nil {
+		n++
+	}
+	if m.Multipart != nil {
+		n++
+	}
+	if len(m.Text) > 0 {
+		n++
+	}
+	if len(m.File) > 0 {
+		n++
+	}
+	if n > 1 {
+		return true, fmt.Errorf("message %s defines more than one of Body, Form, Multipart, Text and File", m.Path)
+	}
+	for i, p := range m.Multipart {
+		if p == nil || len(p.Name) == 0 {
+			return true, fmt.Errorf("message %s multipart part %d without name", m.Path, i)
+		}
+		if len(p.Value) > 0 && len(p.File) > 0 {
+			return true, fmt.Errorf("message %s multipart part %s defines both Value and File", m.Path, p.Name)
+		}
+	}
+	return n > 0, nil
 }
 
 func (m *Request) Check() error {
@@ -25,14 +81,19 @@ func (m *Request) Check() error {
 		return fmt.Errorf("invalid path: %s", m.Path)
 	}
 
+	body, err := m.hasBody()
+	if err != nil {
+		return err
+	}
+
 	switch m.Method {
 	case http.MethodGet:
-		if m.Body != nil {
+		if body {
 			return fmt.Errorf("message %s GET with message body", m.Path)
 		}
 	case http.MethodPut:
 	case http.MethodDelete:
-		if m.Body != nil {
+		if body {
 			return fmt.Errorf("message %s DELETE with message body", m.Path)
 		}
 	case http.MethodPost:
@@ -70,4 +131,53 @@ type Response struct {
 	Success  interface{}     // [dynamic] segments called if error is reported during http request and Check
 	Failure  interface{}     // [dynamic] segments called if any error occurs.
 	Template json.RawMessage // [dynamic] Template is a json compare template to compare with response.
+	Stream   *Stream         // Optional, read response as a stream of events
+}
+
+// stream types
+const (
+	StreamLine = "line"
+	StreamSSE  = "sse"
+)
+
+// Stream defines how a streaming response is processed event by event while it
+// arrives instead of being read as a whole.
+//
+// For each event, the event is written to $(EVENT) and Event is called. The
+// stream stops and fails if Event reports an error, and it stops if Event writes
+// "true" to $(STREAM.STOP), or Until outputs "true", or Count events are read,
+// or Duration passes, or server ends the response. After the stream stops,
+// events received are written to $(RESPONSE) and the response is processed as
+// usual by Template and Check.
+//
+// Note that Test.Timeout limits the whole stream, it should be longer than the
+// stream is expected to last.
+type Stream struct {
+	// Type could be:
+	//   - "line" or "": each non-empty line is an event
+	//   - "sse": Server-Sent Events, each event's data is an event, and its type and
+	//     id are written to $(EVENT.TYPE) and $(EVENT.ID)
+	Type     string
+	Event    interface{} // [dynamic] segments called for each event
+	Until    interface{} // [dynamic] segments called after Event, stream stops if it outputs "true"
+	Count    int         // stop after Count events are read, 0 for no limit
+	Duration string      // stop after duration like "10s", default no limit
+}
+
+// Check validates stream definition.
+func (s *Stream) Check() error {
+	switch s.Type {
+	case "", StreamLine, StreamSSE:
+	default:
+		return fmt.Errorf("unknown stream type %s", s.Type)
+	}
+	if s.Count < 0 {
+		return fmt.Errorf("negative stream count %d", s.Count)
+	}
+	if len(s.Duration) > 0 {
+		if _, err := time.ParseDuration(s.Duration); err != nil {
+			return fmt.Errorf("invalid stream duration %s: %v", s.Duration, err)
+		}
+	}
+	return nil
 }
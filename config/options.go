@@ -11,4 +11,7 @@ type GOptions struct {
 	Final         string            // "-f"
 	GoMarkPort    int               // "-gm"
 	Plugins       string
+	Summary       string // "-summary", path to write run result in json
+	JUnit         string // "-junit", path to write run result in JUnit XML
+	Metrics       string // "-metrics", address like ":9100" to serve prometheus metrics at /metrics
 }
@@ -0,0 +1,121 @@
+package config
+
+import "time"
+
+// Bucket is a non-empty bucket of latency histogram, containing Count latencies
+// in range [From, To].
+type Bucket struct {
+	From  int64
+	To    int64
+	Count int64
+}
+
+// PerfStat is statistics of a schedule or a test, latency is in microseconds.
+//
+// Latency is counted only for successful HTTP requests, Requests counts all
+// requests and Errors counts those failed in HTTP execution or response processing.
+//
+// Failed requests are also counted by failure class in Classes, a class could be:
+//   - conn_refused: connection refused
+//   - timeout: HTTP execution timeout
+//   - dns: domain name resolving fails
+//   - tls: TLS handshake or certificate verification fails
+//   - request: other HTTP execution failures
+//   - redirect: redirect limit of Redirect is reached, which is never retried
+//   - read_body: reading response body fails
+//   - template: response does not match Response.Template
+//   - check: Response.Check fails
+//   - response: other response processing failures
+type PerfStat struct {
+	Requests int64
+	Errors   int64
+	Status   map[int]int64    `json:",omitempty"` // count of each HTTP status code
+	Classes  map[string]int64 `json:",omitempty"` // count of failed requests by failure class
+	Count    int64
+	QPS      int64
+	Max      int64
+	Min      int64
+	Avg      int64
+	P50      int64
+	P90      int64
+	P95      int64
+	P99      int64
+	P999     int64
+	Buckets  []Bucket `json:",omitempty"`
+
+	// Connections counts requests sent on new connections, and Reused counts
+	// those sent on reused keep-alive connections.
+	Connections int64 `json:",omitempty"`
+	Reused      int64 `json:",omitempty"`
+}
+
+// Failure describes a failed request.
+type Failure struct {
+	Class    string // failure class, see PerfStat
+	Message  string // $(FAILURE)
+	URL      string
+	Request  string `json:",omitempty"`
+	Status   string `json:",omitempty"` // empty if server does not respond
+	Response string `json:",omitempty"`
+}
+
+// TestResult is the result of a test in a schedule.
+type TestResult struct {
+	Name string
+	// Passed and Failed count requests of this test, a request fails if HTTP
+	// execution fails or response processing fails.
+	Passed   int64
+	Failed   int64
+	Failures []*Failure `json:",omitempty"` // first failures, at most 10
+	*PerfStat
+}
+
+// MixResult is the result of a weighted mix of a schedule, see Schedule.Mix.
+type MixResult struct {
+	Tests  string
+	Weight int
+	Picked int64   // count of iterations it's picked
+	Ratio  float64 // observed ratio of Picked in all iterations, in [0, 1]
+}
+
+// ScheduleResult is the result of a schedule.
+type ScheduleResult struct {
+	Name    string
+	Success bool
+	Error   string `json:",omitempty"` // error aborts schedule
+	Start   time.Time
+	End     time.Time
+	Passed  int64
+	Failed  int64
+	*PerfStat
+	Tests []*TestResult `json:",omitempty"` // in running order
+	// Backends are results of backends of balanced hosts, named by host key and
+	// backend like "api|http://10.0.0.1:8080".
+	Backends []*TestResult `json:",omitempty"`
+	// Mix are results of weighted mix if schedule defines one, in order of Mix.
+	Mix []*MixResult `json:",omitempty"`
+	// Local and global variables after schedule ends and post processing is done.
+	Local  map[string]string `json:",omitempty"`
+	Global map[string]string `json:",omitempty"`
+}
+
+// ConfigResult is the result of a config.
+type ConfigResult struct {
+	Name      string
+	Path      string `json:",omitempty"` // config file path
+	Success   bool
+	Error     string `json:",omitempty"`
+	Start     time.Time
+	End       time.Time
+	Schedules []*ScheduleResult `json:",omitempty"`
+	DB        map[string]string `json:",omitempty"` // database after config ends
+}
+
+// Result is the result of a gmeter run.
+type Result struct {
+	Success bool
+	Error   string `json:",omitempty"`
+	Start   time.Time
+	End     time.Time
+	Configs []*ConfigResult
+}
@@ -60,6 +60,11 @@ type Route struct {
 	Request  *RequestProcess            // HTTP request processing
 	Response map[string]json.RawMessage // [dynamic] multiple responses template identified by key of map
 	Env      map[string]string          // predefined local variables
+	// WebSocket, if true, upgrades request to a WebSocket connection. Each message
+	// received is written to $(REQUEST) and processed by Request, then the response
+	// chosen by $(RESPONSE) is sent back. Nothing is sent back if it is empty, and
+	// the connection is closed if Request fails.
+	WebSocket bool
 }
 
 // HttpServer defines an HTTP server
@@ -68,6 +73,7 @@ type HttpServer struct {
 	Routes  []*Route          // HTTP server routers
 	Report  Report            // Optional reporter, may used in router processing
 	Env     map[string]string // predefined global variables
+	Metrics string            // optional path like "/metrics" to serve prometheus metrics of gmeter
 }
 
 // HttpServers defines one or more HTTP servers
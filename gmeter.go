@@ -42,6 +42,9 @@ func run() error {
 	gmport := 0
 	fs := ""
 	plugins := ""
+	summary := ""
+	junit := ""
+	metrics := ""
 	flag.StringVar(&variables, "e", "", "predefined global variables k=v, seperated by space if define multiple variables")
 	flag.StringVar(&template, "t", "", "template config file path")
 	flag.StringVar(&template, "template", "", "template config file path")
@@ -51,6 +54,9 @@ func run() error {
 	flag.StringVar(&final, "f", "", "final execute config")
 	flag.StringVar(&fs, "fs", "", "file server: path:port")
 	flag.StringVar(&plugins, "plugin", "", `plugin config json: {"plugins": [{"Path": "so file path", "Symbol": "symbol name", "Param": {...}}, ...]}`)
+	flag.StringVar(&summary, "summary", "", "file path to write run result in json")
+	flag.StringVar(&junit, "junit", "", "file path to write run result in JUnit XML")
+	flag.StringVar(&metrics, "metrics", "", "address to serve prometheus metrics at /metrics, like :9100")
 	flag.IntVar(&gmport, "gm", 7777, "gomark HTTP server, default 7777")
 	flag.Parse()
 
@@ -64,6 +70,9 @@ func run() error {
 		GoMarkPort:    gmport,
 		FileServer:    fs,
 		Plugins:       plugins,
+		Summary:       summary,
+		JUnit:         junit,
+		Metrics:       metrics,
 	}
 
 	var err error
@@ -80,7 +89,8 @@ func run() error {
 		opt.Configs = flag.Args()
 	}
 
-	return meter.Execute(opt)
+	_, err = meter.Execute(opt)
+	return err
 }
 func main() {
 	err := run()
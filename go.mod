@@ -6,6 +6,7 @@ require (
 	github.com/forrestjgq/glog v1.0.1
 	github.com/forrestjgq/gomark v1.0.22
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/huandu/go-clone v1.1.4
 	github.com/pkg/errors v0.9.1
 	github.com/tidwall/sjson v1.1.6
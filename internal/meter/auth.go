@@ -0,0 +1,251 @@
+package meter
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+// tokenSource gets bearer token by running a token test, and caches it in db.
+type tokenSource struct {
+	test    string
+	r       *runner // runner of token test
+	value   segments
+	expires segments // optional
+	key     string
+	mtx     sync.Mutex // one routine gets token at a time
+}
+
+func (ts *tokenSource) expiresKey() string {
+	return ts.key + ".EXPIRES"
+}
+
+// get returns cached token, or a new one if it's not cached or expires.
+func (ts *tokenSource) get(bg *background) (string, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	if tok := bg.dbRead(ts.key); len(tok) > 0 {
+		exp := bg.dbRead(ts.expiresKey())
+		if len(exp) == 0 {
+			return tok, nil
+		}
+		if ms, err := strconv.ParseInt(exp, 10, 64); err == nil && time.Now().Before(time.Unix(0, ms*int64(time.Millisecond))) {
+			return tok, nil
+		}
+	}
+
+	// token test runs in its own local variables and is not counted
+	tb := bg.dup()
+	defer tb.closeClients()
+	tb.perf, tb.fc = nil, nil
+	tb.setError(nil)
+	if decision := ts.r.run(tb); decision != nextContinue || len(tb.failClass) > 0 {
+		return "", errors.Errorf("token test %s fails: %s", ts.test, tb.getLocalEnv(KeyFailure))
+	}
+	tok, err := ts.value.compose(tb)
+	if err != nil {
+		return "", errors.Wrapf(err, "token test %s value", ts.test)
+	}
+	if len(tok) == 0 {
+		return "", errors.Errorf("token test %s gets empty token", ts.test)
+	}
+
+	bg.dbDelete(ts.expiresKey())
+	if ts.expires != nil {
+		s, err := ts.expires.compose(tb)
+		if err != nil {
+			return "", errors.Wrapf(err, "token test %s expires", ts.test)
+		}
+		if len(s) > 0 {
+			du, err := parseLifetime(s)
+			if err != nil {
+				return "", errors.Wrapf(err, "token test %s expires", ts.test)
+			}
+			bg.dbWrite(ts.expiresKey(), strconv.FormatInt(time.Now().Add(du).UnixNano()/int64(time.Millisecond), 10))
+		}
+	}
+	bg.dbWrite(ts.key, tok)
+	return tok, nil
+}
+
+// drop removes cached token if it is still tok, for another routine may have got
+// a new one.
+func (ts *tokenSource) drop(bg *background, tok string) {
+	ts.mtx.Lock()
+	if bg.dbRead(ts.key) == tok {
+		bg.dbDelete(ts.key)
+		bg.dbDelete(ts.expiresKey())
+	}
+	ts.mtx.Unlock()
+}
+
+// parseLifetime parses seconds like "3600" or duration like "1h".
+func parseLifetime(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// signer signs request by HMAC.
+type signer struct {
+	secret   segments
+	hash     func() hash.Hash
+	base64   bool
+	header   string
+	tsHeader string
+}
+
+// authenticator adds authentication headers to requests of a test.
+type authenticator struct {
+	user, password segments     // basic auth, optional
+	token          *tokenSource // optional
+	tokenHeader    string
+	sign           *signer // optional
+}
+
+func makeAuthenticator(a *config.Auth) (*authenticator, error) {
+	if err := a.Check(); err != nil {
+		return nil, err
+	}
+	au := &authenticator{}
+	var err error
+	if b := a.Basic; b != nil {
+		if au.user, err = makeSegments(b.User); err != nil {
+			return nil, errors.Wrapf(err, "basic auth user")
+		}
+		if au.password, err = makeSegments(b.Password); err != nil {
+			return nil, errors.Wrapf(err, "basic auth password")
+		}
+	}
+	if t := a.Token; t != nil {
+		au.tokenHeader = t.Header
+		if len(au.tokenHeader) == 0 {
+			au.tokenHeader = "Authorization"
+		}
+	}
+	if s := a.Sign; s != nil {
+		sg := &signer{
+			hash:     sha256.New,
+			base64:   s.Encoding == "base64",
+			header:   s.Header,
+			tsHeader: s.TimestampHeader,
+		}
+		switch s.Algorithm {
+		case "sha1":
+			sg.hash = sha1.New
+		case "sha512":
+			sg.hash = sha512.New
+		}
+		if len(sg.header) == 0 {
+			sg.header = "X-Signature"
+		}
+		if len(sg.tsHeader) == 0 {
+			sg.tsHeader = "X-Timestamp"
+		}
+		if sg.secret, err = makeSegments(s.Secret); err != nil {
+			return nil, errors.Wrapf(err, "sign secret")
+		}
+		au.sign = sg
+	}
+	return au, nil
+}
+
+// makeTokenSource creates token source running token test r, and caching token
+// at db key.
+func makeTokenSource(t *config.Token, key string, r *runner) (*tokenSource, error) {
+	ts := &tokenSource{
+		test: t.Test,
+		r:    r,
+		key:  key,
+	}
+	var err error
+	if ts.value, err = makeSegments(t.Value); err != nil {
+		return nil, errors.Wrapf(err, "token value")
+	}
+	if len(t.ExpiresIn) > 0 {
+		if ts.expires, err = makeSegments(t.ExpiresIn); err != nil {
+			return nil, errors.Wrapf(err, "token expires")
+		}
+	}
+	return ts, nil
+}
+
+// rejected tells if server rejects the token by status.
+func (au *authenticator) rejected(status int) bool {
+	return au.token != nil && status == http.StatusUnauthorized
+}
+
+// refresh drops the token sent so that next apply gets a new one.
+func (au *authenticator) refresh(bg *background) {
+	if au.token != nil {
+		au.token.drop(bg, bg.getLocalEnv(KeyToken))
+	}
+}
+
+// apply returns a copy of headers with authentication headers added for a
+// request of method to addr with payload sent on wire.
+func (au *authenticator) apply(bg *background, method, addr, payload string, headers map[string]string) (map[string]string, error) {
+	if au.user != nil {
+		user, err := au.user.compose(bg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compose basic auth user")
+		}
+		password, err := au.password.compose(bg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compose basic auth password")
+		}
+		cred := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+		headers = setHeader(headers, "Authorization", "Basic "+cred, true)
+	}
+	if au.token != nil {
+		tok, err := au.token.get(bg)
+		if err != nil {
+			return nil, err
+		}
+		bg.setLocalEnv(KeyToken, tok)
+		if http.CanonicalHeaderKey(au.tokenHeader) == "Authorization" {
+			headers = setHeader(headers, au.tokenHeader, "Bearer "+tok, true)
+		} else {
+			headers = setHeader(headers, au.tokenHeader, tok, true)
+		}
+	}
+	if sg := au.sign; sg != nil {
+		secret, err := sg.secret.compose(bg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compose sign secret")
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse url %s", addr)
+		}
+		if len(method) == 0 {
+			method = http.MethodGet
+		}
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sg.hash, []byte(secret))
+		mac.Write([]byte(method + "\n" + u.RequestURI() + "\n" + ts + "\n" + payload))
+		sum := mac.Sum(nil)
+		sig := hex.EncodeToString(sum)
+		if sg.base64 {
+			sig = base64.StdEncoding.EncodeToString(sum)
+		}
+		headers = setHeader(headers, sg.header, sig, true)
+		headers = setHeader(headers, sg.tsHeader, ts, true)
+	}
+	return headers, nil
+}
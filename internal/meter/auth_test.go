@@ -0,0 +1,106 @@
+package meter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestAuth(t *testing.T) {
+	c := &config.HttpServers{
+		Servers: map[string]*config.HttpServer{
+			"auth": {
+				Address: "127.0.0.1:0",
+				Routes: []*config.Route{
+					{
+						Method: "POST",
+						Path:   "/token",
+						Request: &config.RequestProcess{
+							Check: "`db -w srv.tokens x$(@db -r srv.tokens)`",
+						},
+						Response: map[string]json.RawMessage{
+							"token": json.RawMessage(`{"access_token": "tok$(@strlen $(@db -r srv.tokens))", "expires_in": 3600}`),
+						},
+					},
+				},
+			},
+		},
+	}
+	err := StartHTTPServerConfig(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer StopAll()
+
+	var mtx sync.Mutex
+	var sig, ts string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data":
+			// the first token is always rejected
+			if r.Header.Get("Authorization") == "Bearer tok1" || r.Header.Get("X-Token") == "tok1" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		case "/basic":
+			if u, p, ok := r.BasicAuth(); !ok || u != "bob" || p != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		case "/signed":
+			mtx.Lock()
+			sig, ts = r.Header.Get("X-Signature"), r.Header.Get("X-Timestamp")
+			mtx.Unlock()
+		}
+	}))
+	defer api.Close()
+
+	cfg := loadFixture(t, "auth.json", "http://127.0.0.1:"+strconv.Itoa(servers["auth"].port))
+	cfg.Hosts["api"].Host = api.URL
+	cfg.Hosts["basic"].Host = api.URL
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+
+	// tok1 is rejected and refreshed to tok2, which is cached for later requests,
+	// then each request of short lived token gets a new one
+	if cr.DB["data"] != "tok2" || cr.DB["TOKEN.login"] != "tok2" {
+		t.Errorf("expect token refreshed to tok2, got %s", cr.DB["data"])
+	}
+	if cr.DB["short.token"] != "tok4" || cr.DB["srv.tokens"] != "xxxx" {
+		t.Errorf("expect token expires, got %s of %s tokens", cr.DB["short.token"], cr.DB["srv.tokens"])
+	}
+	if res := cr.Schedules[0].Tests[0]; res.PerfStat.Requests != 3 || res.Failed != 0 {
+		t.Errorf("expect 3 successful requests, got %d requests %d failed", res.PerfStat.Requests, res.Failed)
+	}
+	if cr.DB["basic"] != "ok" {
+		t.Errorf("basic auth fails")
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("POST\n/signed?v=1\n" + ts + "\nhello"))
+	if cr.DB["signed"] != "ok" || sig != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("unexpected signature %s", sig)
+	}
+
+	for _, a := range []*config.Auth{
+		{Basic: &config.BasicAuth{}},
+		{Basic: &config.BasicAuth{User: "bob"}, Token: &config.Token{Test: "login", Value: "$(RESPONSE)"}},
+		{Token: &config.Token{Test: "login"}},
+		{Sign: &config.Sign{Secret: "s", Algorithm: "md5"}},
+	} {
+		if err = a.Check(); err == nil {
+			t.Errorf("expect auth %+v invalid", a)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package meter
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+	"github.com/pkg/errors"
+)
+
+// backend is a server of a balanced host.
+type backend struct {
+	url        string
+	weight     int
+	inFlight   int
+	ejectUntil time.Time
+	perf       *perf
+}
+
+// balancer chooses a backend for each request of a host among its backends.
+type balancer struct {
+	name     string // host key
+	policy   string
+	eject    time.Duration
+	backends []*backend
+	next     int // next backend for round-robin
+	mtx      sync.Mutex
+}
+
+func makeBalancer(plan, key string, h *config.Host) (*balancer, error) {
+	b := &balancer{
+		name:   key,
+		policy: h.Balance,
+	}
+	if len(b.policy) == 0 {
+		b.policy = config.BalanceRoundRobin
+	}
+	if len(h.Eject) > 0 {
+		var err error
+		if b.eject, err = time.ParseDuration(h.Eject); err != nil {
+			return nil, errors.Wrapf(err, "parse eject %s", h.Eject)
+		}
+	}
+	for i, be := range h.Backends {
+		weight := be.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		b.backends = append(b.backends, &backend{
+			url:    be.Host,
+			weight: weight,
+			perf:   makePerf(plan + "_" + key + "_backend" + strconv.Itoa(i)),
+		})
+	}
+	if len(b.backends) == 0 {
+		return nil, errors.Errorf("host %s defines no backends", key)
+	}
+	return b, nil
+}
+
+// available returns backends not ejected, or the one whose cool-down ends first
+// if all are ejected.
+func (b *balancer) available(now time.Time) []*backend {
+	var ret []*backend
+	var first *backend
+	for _, be := range b.backends {
+		if !now.Before(be.ejectUntil) {
+			ret = append(ret, be)
+		} else if first == nil || be.ejectUntil.Before(first.ejectUntil) {
+			first = be
+		}
+	}
+	if len(ret) == 0 {
+		ret = append(ret, first)
+	}
+	return ret
+}
+
+// pick chooses a backend and counts it in flight, caller must call done after
+// request ends.
+func (b *balancer) pick() *backend {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	candidates := b.available(time.Now())
+	var be *backend
+	switch b.policy {
+	case config.BalanceRandom:
+		be = candidates[rand.Intn(len(candidates))]
+	case config.BalanceWeighted:
+		total := 0
+		for _, c := range candidates {
+			total += c.weight
+		}
+		n := rand.Intn(total)
+		for _, c := range candidates {
+			if n < c.weight {
+				be = c
+				break
+			}
+			n -= c.weight
+		}
+	case config.BalanceLeastInFlight:
+		for _, c := range candidates {
+			if be == nil || c.inFlight < be.inFlight {
+				be = c
+			}
+		}
+	default:
+		// first available backend starting from next
+		for i := 0; i < len(b.backends) && be == nil; i++ {
+			c := b.backends[(b.next+i)%len(b.backends)]
+			for _, a := range candidates {
+				if a == c {
+					be = c
+					b.next = (b.next + i + 1) % len(b.backends)
+					break
+				}
+			}
+		}
+	}
+	be.inFlight++
+	return be
+}
+
+// done ends a request on be, be will be ejected if failed is true.
+func (b *balancer) done(be *backend, failed bool) {
+	b.mtx.Lock()
+	be.inFlight--
+	if failed && b.eject > 0 {
+		be.ejectUntil = time.Now().Add(b.eject)
+	}
+	b.mtx.Unlock()
+}
+
+// results commits perf of each backend, named as "key|url".
+func (b *balancer) results() []*config.TestResult {
+	var ret []*config.TestResult
+	for _, be := range b.backends {
+		ret = append(ret, be.perf.result(b.name+"|"+be.url))
+	}
+	return ret
+}
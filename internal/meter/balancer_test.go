@@ -0,0 +1,98 @@
+package meter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestBalancerPick(t *testing.T) {
+	h := &config.Host{
+		Backends: []*config.Backend{{Host: "http://a"}, {Host: "http://b"}},
+		Balance:  config.BalanceLeastInFlight,
+		Eject:    "1m",
+	}
+	if err := h.Check(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	lb, err := makeBalancer("pick", "-", h)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	a := lb.pick()
+	b := lb.pick()
+	if a == b {
+		t.Errorf("expect least in flight backend chosen")
+	}
+	lb.done(a, true)
+	if c := lb.pick(); c != b {
+		t.Errorf("expect ejected backend %s not chosen", a.url)
+	}
+	lb.done(b, true)
+	b.ejectUntil = b.ejectUntil.Add(time.Minute)
+	if c := lb.pick(); c != a {
+		t.Errorf("expect backend %s ejected first chosen", a.url)
+	}
+
+	for _, h := range []*config.Host{
+		{Backends: []*config.Backend{{Host: "ftp://a"}}},
+		{Backends: []*config.Backend{{Host: "http://a", Weight: -1}}},
+		{Backends: []*config.Backend{{Host: "http://a"}}, Balance: "unknown"},
+		{Backends: []*config.Backend{{Host: "http://a"}}, Eject: "1"},
+	} {
+		if err = h.Check(); err == nil {
+			t.Errorf("expect host %v invalid", h)
+		}
+	}
+}
+
+func TestBalance(t *testing.T) {
+	var urls []string
+	for _, name := range []string{"a", "b"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	urls = append(urls, dead.URL)
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busy.Close()
+	urls = append(urls, busy.URL)
+
+	cfg := loadFixture(t, "balance.json", "")
+	for i, be := range cfg.Hosts["-"].Backends {
+		be.Host = urls[i]
+	}
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	if cr.DB["a"] != urls[0] || cr.DB["b"] != urls[1] {
+		t.Errorf("unexpected backends: %v", cr.DB)
+	}
+
+	// dead and busy backends are ejected after first failure
+	expect := []int64{3, 2, 1, 1}
+	res := cr.Schedules[0].Backends
+	if len(res) != len(expect) {
+		t.Fatalf("expect %d backend results, got %d", len(expect), len(res))
+	}
+	for i, r := range res {
+		if r.Name != "-|"+urls[i] || r.PerfStat.Requests != expect[i] {
+			t.Errorf("backend %s expect %d requests, got %d", r.Name, expect[i], r.PerfStat.Requests)
+		}
+	}
+	if res[2].Failed != 1 || res[0].Failed != 0 {
+		t.Errorf("unexpected backend failures: %d %d", res[0].Failed, res[2].Failed)
+	}
+}
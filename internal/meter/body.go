@@ -0,0 +1,205 @@
+package meter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/forrestjgq/gmeter/config"
+	"github.com/pkg/errors"
+)
+
+// Content-Type of bodies other than json
+const (
+	ctForm = "application/x-www-form-urlencoded"
+	ctText = "text/plain; charset=utf-8"
+	ctFile = "application/octet-stream"
+)
+
+// formSource composes form fields into url encoded body.
+type formSource struct {
+	names  []string
+	values []segments
+}
+
+func (f *formSource) iterable() bool {
+	for _, v := range f.values {
+		if v.iterable() {
+			return true
+		}
+	}
+	return false
+}
+func (f *formSource) compose(bg *background) (string, error) {
+	form := make(url.Values)
+	for i, name := range f.names {
+		v, err := f.values[i].compose(bg)
+		if err != nil {
+			return "", errors.Wrapf(err, "compose form field %s", name)
+		}
+		form.Set(name, v)
+	}
+	return form.Encode(), nil
+}
+
+// readFile reads content of file, relative path is relative to $(TPATH).
+func readFile(bg *background, path string) (string, error) {
+	p, err := loadFilePath(bg.getGlobalEnv(KeyTPath), path)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "read file %s", p)
+	}
+	return string(b), nil
+}
+
+// fileSource reads a file as body.
+type fileSource struct {
+	path segments
+}
+
+func (f *fileSource) iterable() bool {
+	return f.path.iterable()
+}
+func (f *fileSource) compose(bg *background) (string, error) {
+	path, err := f.path.compose(bg)
+	if err != nil {
+		return "", errors.Wrapf(err, "compose file path")
+	}
+	return readFile(bg, path)
+}
+
+type partSource struct {
+	name        string
+	value, file segments
+	contentType string
+}
+
+// multipartSource composes parts into multipart/form-data body, a fixed boundary
+// is used so that Content-Type could be decided before composing.
+type multipartSource struct {
+	boundary string
+	parts    []*partSource
+}
+
+func (m *multipartSource) iterable() bool {
+	for _, p := range m.parts {
+		if p.value.iterable() || p.file.iterable() {
+			return true
+		}
+	}
+	return false
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (m *multipartSource) compose(bg *background) (string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.SetBoundary(m.boundary); err != nil {
+		return "", err
+	}
+	for _, p := range m.parts {
+		if len(p.file) == 0 {
+			v, err := p.value.compose(bg)
+			if err != nil {
+				return "", errors.Wrapf(err, "compose part %s", p.name)
+			}
+			if err = w.WriteField(p.name, v); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		path, err := p.file.compose(bg)
+		if err != nil {
+			return "", errors.Wrapf(err, "compose part %s file path", p.name)
+		}
+		content, err := readFile(bg, path)
+		if err != nil {
+			return "", errors.Wrapf(err, "part %s", p.name)
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", `form-data; name="`+quoteEscaper.Replace(p.name)+
+			`"; filename="`+quoteEscaper.Replace(filepath.Base(path))+`"`)
+		h.Set("Content-Type", p.contentType)
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return "", err
+		}
+		if _, err = pw.Write([]byte(content)); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// makeBodySource creates source of request body and returns the Content-Type it
+// should be sent with, which is empty for json body.
+func makeBodySource(req *config.Request) (feedSource, string, error) {
+	switch {
+	case req.Form != nil:
+		f := &formSource{}
+		for k := range req.Form {
+			f.names = append(f.names, k)
+		}
+		sort.Strings(f.names)
+		for _, k := range f.names {
+			s, err := makeSegments(req.Form[k])
+			if err != nil {
+				return nil, "", errors.Wrapf(err, "form field %s", k)
+			}
+			f.values = append(f.values, s)
+		}
+		return f, ctForm, nil
+	case req.Multipart != nil:
+		m := &multipartSource{
+			boundary: multipart.NewWriter(nil).Boundary(),
+		}
+		for _, p := range req.Multipart {
+			ps := &partSource{name: p.Name, contentType: p.ContentType}
+			var err error
+			if len(p.File) > 0 {
+				ps.file, err = makeSegments(p.File)
+				if len(ps.contentType) == 0 {
+					ps.contentType = ctFile
+				}
+			} else {
+				ps.value, err = makeSegments(p.Value)
+			}
+			if err != nil {
+				return nil, "", errors.Wrapf(err, "part %s", p.Name)
+			}
+			m.parts = append(m.parts, ps)
+		}
+		return m, "multipart/form-data; boundary=" + m.boundary, nil
+	case len(req.Text) > 0:
+		s, err := makeSegments(req.Text)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "text body")
+		}
+		return s, ctText, nil
+	case len(req.File) > 0:
+		s, err := makeSegments(req.File)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "file body")
+		}
+		return &fileSource{path: s}, ctFile, nil
+	default:
+		s, err := makeSegments(string(req.Body))
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "body")
+		}
+		return s, "", nil
+	}
+}
@@ -0,0 +1,83 @@
+package meter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestRequestBody(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join(fixtureDir, "body.bin"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	hit := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok := false
+		ct := r.Header.Get("Content-Type")
+		switch r.URL.Path {
+		case "/form":
+			ok = ct == "application/x-www-form-urlencoded" && r.ParseForm() == nil &&
+				r.PostForm.Get("name") == "gmeter" && r.PostForm.Get("len") == "3"
+		case "/multipart":
+			if err := r.ParseMultipartForm(1 << 20); err == nil {
+				f, h, err := r.FormFile("data")
+				if err == nil {
+					b, _ := ioutil.ReadAll(f)
+					ok = r.FormValue("name") == "gmeter" && h.Filename == "body.bin" &&
+						h.Header.Get("Content-Type") == "image/png" && string(b) == string(data)
+				}
+			}
+		case "/text":
+			b, _ := ioutil.ReadAll(r.Body)
+			ok = ct == "text/plain; charset=utf-8" && string(b) == "hello 3"
+		case "/file":
+			b, _ := ioutil.ReadAll(r.Body)
+			ok = ct == "application/octet-stream" && string(b) == string(data)
+		case "/json":
+			// headers of a JSON body request are sent as they are
+			b, _ := ioutil.ReadAll(r.Body)
+			ok = r.Header.Get("X-Token") == "abc" && r.Header.Get("X-Length") == "5" &&
+				string(b) == `{"seq":1}`
+		case "/header":
+			b, _ := ioutil.ReadAll(r.Body)
+			ok = ct == "text/csv" && len(r.Header["Content-Type"]) == 1 &&
+				r.Header.Get("X-Length") == "4" && string(b) == "a,b"
+		}
+		hit[r.URL.Path] = ok
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	if _, err = runFixture(t, "body.json", srv.URL); err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	for _, p := range []string{"/form", "/multipart", "/text", "/file", "/header", "/json"} {
+		if !hit[p] {
+			t.Errorf("request %s not expected", p)
+		}
+	}
+}
+
+func TestRequestBodyCheck(t *testing.T) {
+	cases := []*config.Request{
+		{Method: "POST", Path: "/", Text: "a", File: "b"},
+		{Method: "POST", Path: "/", Body: json.RawMessage("{}"), Form: map[string]string{"a": "b"}},
+		{Method: "POST", Path: "/", Multipart: []*config.Part{{Value: "a"}}},
+		{Method: "POST", Path: "/", Multipart: []*config.Part{{Name: "a", Value: "a", File: "b"}}},
+		{Method: "GET", Path: "/", Text: "a"},
+	}
+	for i, c := range cases {
+		if err := c.Check(); err == nil {
+			t.Errorf("case %d expect check fail", i)
+		}
+	}
+}
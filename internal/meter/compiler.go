@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -1332,6 +1334,136 @@ func makeDB(v []string) (command, error) {
 	return c, nil
 }
 
+////////////////////////////////////////////////////////////////////////////////
+//////////                           cookie                          ///////////
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	cookieRead = iota
+	cookieWrite
+	cookieClear
+)
+
+type cmdCookie struct {
+	op    int
+	url   segments
+	name  segments
+	value segments
+	raw   string
+}
+
+func (c *cmdCookie) iterable() bool {
+	return false
+}
+func (c *cmdCookie) close() {
+	c.url = nil
+	c.name = nil
+	c.value = nil
+}
+
+func (c *cmdCookie) execute(bg *background) (string, error) {
+	if bg.jar == nil {
+		return "", errors.Errorf("%s: cookie jar is not enabled", c.raw)
+	}
+	if c.op == cookieClear {
+		bg.resetJar()
+		return "", nil
+	}
+
+	str, err := c.url.compose(bg)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s compose url", c.raw)
+	}
+	u, err := url.Parse(str)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s parse url %s", c.raw, str)
+	}
+	name, err := c.name.compose(bg)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s compose name", c.raw)
+	}
+
+	if c.op == cookieWrite {
+		value, err := c.value.compose(bg)
+		if err != nil {
+			return "", errors.Wrapf(err, "%s compose value", c.raw)
+		}
+		bg.jar.SetCookies(u, []*http.Cookie{{Name: name, Value: value, Path: "/"}})
+		return "", nil
+	}
+
+	for _, ck := range bg.jar.Cookies(u) {
+		if ck.Name == name {
+			return ck.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// cookie [-u url] -r name // default
+// cookie [-u url] -w name value...
+// cookie -c
+func makeCookie(v []string) (command, error) {
+	raw := "cookie " + strings.Join(v, " ")
+	read, write, clear := false, false, false
+	u := ""
+	fs := flag.NewFlagSet("cookie", flag.ContinueOnError)
+	fs.BoolVar(&read, "r", false, "read cookie")
+	fs.BoolVar(&write, "w", false, "write cookie")
+	fs.BoolVar(&clear, "c", false, "clear all cookies")
+	fs.StringVar(&u, "u", "$("+KeyURL+")", "url cookie belongs to")
+	err := fs.Parse(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s parse argument", raw)
+	}
+	v = fs.Args()
+
+	cnt := 0
+	for _, b := range []bool{read, write, clear} {
+		if b {
+			cnt++
+		}
+	}
+	if cnt > 1 {
+		return nil, errors.New("cookie only accept one of -r/-w/-c")
+	}
+
+	c := &cmdCookie{
+		raw: raw,
+		op:  cookieRead,
+	}
+	if clear {
+		c.op = cookieClear
+		if len(v) > 0 {
+			return nil, errors.New("cookie: too much argument")
+		}
+		return c, nil
+	}
+
+	if len(v) == 0 {
+		return nil, errors.Errorf("%s cookie name not provided", raw)
+	}
+	if c.url, err = makeSegments(u); err != nil {
+		return nil, errors.Wrapf(err, "%s make url", raw)
+	}
+	if c.name, err = makeSegments(v[0]); err != nil {
+		return nil, errors.Wrapf(err, "%s make name", raw)
+	}
+	if write {
+		c.op = cookieWrite
+		content := "$(" + KeyInput + ")"
+		if len(v) > 1 {
+			content = strings.Join(v[1:], " ")
+		}
+		if c.value, err = makeSegments(content); err != nil {
+			return nil, errors.Wrapf(err, "%s make content", raw)
+		}
+	} else if len(v) > 1 {
+		return nil, errors.New("cookie: too much argument")
+	}
+	return c, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //////////                            list                           ///////////
 ////////////////////////////////////////////////////////////////////////////////
@@ -1936,6 +2068,7 @@ func init() {
 		"cat":     makeCat,
 		"env":     makeEnv,
 		"db":      makeDB,
+		"cookie":  makeCookie,
 		"write":   makeWrite,
 		"assert":  makeAssert,
 		"eval":    makeEval,
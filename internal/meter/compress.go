@@ -0,0 +1,104 @@
+package meter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// content encodings supported
+const (
+	encGzip    = "gzip"
+	encDeflate = "deflate"
+)
+
+func checkEncoding(enc string) error {
+	switch enc {
+	case encGzip, encDeflate:
+		return nil
+	}
+	return errors.Errorf("unsupported encoding %s", enc)
+}
+
+// compressBody compresses body by encoding enc.
+func compressBody(enc string, body string) (string, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch enc {
+	case encGzip:
+		w = gzip.NewWriter(&buf)
+	case encDeflate:
+		w = zlib.NewWriter(&buf)
+	default:
+		return "", checkEncoding(enc)
+	}
+	if _, err := w.Write([]byte(body)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// setHeader returns a copy of headers with header k set to v, an existing header
+// is kept unless override is true. Header name is case-insensitive.
+func setHeader(headers map[string]string, k, v string, override bool) map[string]string {
+	ret := make(map[string]string)
+	for name, value := range headers {
+		if strings.EqualFold(name, k) {
+			if !override {
+				return headers
+			}
+			continue
+		}
+		ret[name] = value
+	}
+	ret[k] = v
+	return ret
+}
+
+// countReader counts bytes read from a response body.
+type countReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodedBody reads decompressed body, and closes the body it decompresses.
+type decodedBody struct {
+	io.Reader
+	body io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	return d.body.Close()
+}
+
+// decodeBody returns a reader decompressing body by encoding enc, body is
+// returned as it is if it's not compressed.
+func decodeBody(body io.ReadCloser, enc string) (io.ReadCloser, error) {
+	var rd io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(enc)) {
+	case encGzip, "x-gzip":
+		rd, err = gzip.NewReader(body)
+	case encDeflate:
+		rd, err = zlib.NewReader(body)
+	default:
+		return body, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode %s", enc)
+	}
+	return &decodedBody{Reader: rd, body: body}, nil
+}
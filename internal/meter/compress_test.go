@@ -0,0 +1,73 @@
+package meter
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rd, err := decodeBody(r.Body, r.Header.Get("Content-Encoding"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := ioutil.ReadAll(rd)
+		if r.Header.Get("Content-Encoding") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// /raw responds gzip even if client does not accept it
+		if r.URL.Path != "/raw" && !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			_, _ = w.Write(b)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		_, _ = gw.Write(b)
+		_ = gw.Close()
+	}))
+	defer srv.Close()
+
+	cfg := loadFixture(t, "compress.json", srv.URL)
+	text := strings.Repeat("hello ", 100)
+	cfg.Tests["gzip"].RequestMessage.Text = text
+	cfg.Tests["raw"].RequestMessage.Text = text
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	if cr.DB["gzip"] != text || cr.DB["gzip.size"] != "600" {
+		t.Errorf("unexpected gzip response %s size %s", cr.DB["gzip"], cr.DB["gzip.size"])
+	}
+	if cr.DB["deflate"] != "world" {
+		t.Errorf("unexpected deflate response %s", cr.DB["deflate"])
+	}
+	// response is not decompressed without Decompress
+	if size, _ := strconv.Atoi(cr.DB["raw.size"]); cr.DB["raw.size"] != cr.DB["raw.wire"] || size <= 0 || size >= len(text) {
+		t.Errorf("expect raw gzip response, size %s wire size %s", cr.DB["raw.size"], cr.DB["raw.wire"])
+	}
+
+	for _, enc := range []string{encGzip, encDeflate} {
+		s, err := compressBody(enc, text)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		rd, err := decodeBody(ioutil.NopCloser(strings.NewReader(s)), enc)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if b, _ := ioutil.ReadAll(rd); string(b) != text {
+			t.Errorf("%s decode mismatch: %s", enc, string(b))
+		}
+	}
+	if err = checkEncoding("br"); err == nil {
+		t.Errorf("expect br unsupported")
+	}
+}
@@ -47,6 +47,7 @@ func (d *dynamicConsumer) processResponse(bg *background) next {
 func (d *dynamicConsumer) process(bg *background, key string) next {
 	if d.template != nil {
 		if err := compareTemplate(d.template, bg, bg.getLocalEnv(key)); err != nil {
+			bg.failClass = failTemplate
 			return d.processFailure(bg, err)
 		}
 	}
@@ -54,6 +55,7 @@ func (d *dynamicConsumer) process(bg *background, key string) next {
 	if d.check != nil {
 		_, err := d.check.compose(bg)
 		if err != nil {
+			bg.failClass = failCheck
 			return d.processFailure(bg, err)
 		}
 	}
@@ -83,6 +85,9 @@ func (d *dynamicConsumer) processSuccess(bg *background) {
 }
 func (d *dynamicConsumer) processFailure(bg *background, err error) next {
 	err = errors.Wrap(err, "process failure")
+	if len(bg.failClass) == 0 {
+		bg.failClass = failResponse
+	}
 	// move error to failure if any to make sure fail processing without any error
 	bg.setLocalEnv(KeyFailure, err.Error())
 	bg.setError(nil)
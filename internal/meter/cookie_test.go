@@ -0,0 +1,43 @@
+package meter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestCookieJar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		case "/echo":
+			s, err1 := r.Cookie("session")
+			l, err2 := r.Cookie("lang")
+			if err1 != nil || err2 != nil {
+				_, _ = w.Write([]byte("none"))
+			} else {
+				_, _ = w.Write([]byte(s.Value + l.Value))
+			}
+		}
+	}))
+	defer srv.Close()
+
+	cfg := loadFixture(t, "cookie.json", srv.URL)
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	if cr.DB["session"] != "abc" {
+		t.Errorf("expect session abc, get %s", cr.DB["session"])
+	}
+
+	// without jar no cookie is kept
+	delete(cfg.Options, config.OptionCookieJar)
+	if _, err = runConfig(cfg); err == nil {
+		t.Errorf("expect fail without cookie jar")
+	}
+}
@@ -0,0 +1,69 @@
+package meter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestDialer(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "gmeter.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer l.Close()
+	go func() {
+		_ = http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Host))
+		}))
+	}()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host + " " + r.TLS.ServerName))
+	}))
+	defer srv.Close()
+
+	cfg := loadFixture(t, "dial.json", "")
+	cfg.Hosts["sock"].Host = config.UnixPrefix + sock
+	cfg.Hosts["resolved"].Resolve["example.com:8443"] = srv.Listener.Addr().String()
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	if v := cr.DB["resolved"]; v != "example.com:8443 example.com" {
+		t.Errorf("unexpected host and server name: %s", v)
+	}
+
+	for _, h := range []*config.Host{
+		{Host: "unix://"},
+		{Host: "unix:///tmp/a.sock", Proxy: "http://127.0.0.1:8080"},
+		{Host: "http://example.com", Resolve: map[string]string{"example.com": "127.0.0.1"}},
+	} {
+		if err = h.Check(); err == nil {
+			t.Errorf("expect host %s invalid", h.Host)
+		}
+	}
+}
+
+func TestHostKey(t *testing.T) {
+	abs, err := filepath.Abs(fixtureDir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	k := hostKey(config.UnixPrefix+"gmeter.sock", fixtureDir)
+	if k != hostKey(config.UnixPrefix+abs+"/gmeter.sock", "") {
+		t.Fatalf("expect same key of same socket, get %s", k)
+	}
+	if hostKey(config.UnixPrefix+"gmeter.sock", t.TempDir()) == k {
+		t.Fatalf("expect different key of different sockets")
+	}
+	if k = hostKey("http://example.com", fixtureDir); k != "http://example.com" {
+		t.Fatalf("unexpected key %s", k)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/forrestjgq/gmeter/gplugin"
 
@@ -38,10 +39,51 @@ func startGomark(port int) {
 	})
 }
 
-func Execute(opt *config.GOptions) error {
+// writeSummary writes result to path in json
+func writeSummary(path string, res *config.Result) error {
+	b, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+// Execute runs gmeter by options and returns result of all configs executed. Result
+// is always valid even if error is returned.
+func Execute(opt *config.GOptions) (res *config.Result, err error) {
+	res = &config.Result{
+		Start: time.Now(),
+	}
+	defer func() {
+		res.End = time.Now()
+		res.Success = err == nil
+		if err != nil {
+			res.Error = err.Error()
+		}
+		if len(opt.Summary) > 0 {
+			if e := writeSummary(opt.Summary, res); e != nil {
+				glog.Errorf("write summary to %s: %v", opt.Summary, e)
+			}
+		}
+		if len(opt.JUnit) > 0 {
+			if e := writeJUnit(opt.JUnit, res); e != nil {
+				glog.Errorf("write junit report to %s: %v", opt.JUnit, e)
+			}
+		}
+	}()
+
 	startGomark(opt.GoMarkPort)
 
-	_, err := startPerf(0)
+	if len(opt.Metrics) > 0 {
+		if err = startMetrics(opt.Metrics); err != nil {
+			return res, errors.Wrapf(err, "start metrics server at %s", opt.Metrics)
+		}
+	}
+
+	_, err = startPerf(0)
 	if err != nil {
 		defer stopPerf()
 	}
@@ -55,7 +97,7 @@ func Execute(opt *config.GOptions) error {
 	if len(opt.Plugins) > 0 {
 		err = gplugin.LoadPlugins(opt.Plugins)
 		if err != nil {
-			return errors.Wrapf(err, "load plugin config %s", opt.Plugins)
+			return res, errors.Wrapf(err, "load plugin config %s", opt.Plugins)
 		}
 	}
 
@@ -63,7 +105,7 @@ func Execute(opt *config.GOptions) error {
 	if len(opt.HTTPServerCfg) > 0 {
 		err := StartHTTPServer(opt.HTTPServerCfg)
 		if err != nil {
-			return errors.Wrapf(err, "HTTP server start")
+			return res, errors.Wrapf(err, "HTTP server start")
 		} else {
 			hasServer = true
 		}
@@ -81,12 +123,12 @@ func Execute(opt *config.GOptions) error {
 	if opt.FileServer != "" {
 		s := strings.Split(opt.FileServer, ":")
 		if len(s) != 2 {
-			return errors.Errorf("invalid file server %s, expect path:port format", opt.FileServer)
+			return res, errors.Errorf("invalid file server %s, expect path:port format", opt.FileServer)
 		}
 		path := strings.TrimSpace(s[0])
 		port := strings.TrimSpace(s[1])
 		if len(path) == 0 || len(port) == 0 {
-			return errors.Errorf("invalid file server config %s", opt.FileServer)
+			return res, errors.Errorf("invalid file server config %s", opt.FileServer)
 		}
 		hasServer = true
 		go func() {
@@ -121,7 +163,9 @@ func Execute(opt *config.GOptions) error {
 		if err != nil {
 			return errors.Wrapf(err, "get abs config path %s", path)
 		}
-		err = StartConfig(c)
+		cr, err := runConfig(c)
+		cr.Path = path
+		res.Configs = append(res.Configs, cr)
 		if err != nil {
 			return errors.Wrap(err, "test "+path)
 		}
@@ -194,7 +238,7 @@ func Execute(opt *config.GOptions) error {
 		for _, c := range opt.Configs {
 			err = doSingle(c)
 			if err != nil {
-				return errors.Wrapf(err, "do %s", c)
+				return res, errors.Wrapf(err, "do %s", c)
 			}
 		}
 	} else if hasServer {
@@ -203,7 +247,7 @@ func Execute(opt *config.GOptions) error {
 		w.Wait()
 	}
 
-	return nil
+	return res, nil
 }
 
 var lperf net.Listener
@@ -1,6 +1,9 @@
 package meter
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/forrestjgq/gmeter/config"
@@ -19,7 +22,7 @@ func TestExecute(t *testing.T) {
 		Final:          "",
 	}
 
-	err := Execute(opt)
+	_, err := Execute(opt)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
@@ -38,7 +41,7 @@ func TestExecuteList(t *testing.T) {
 		Final:          "test/base/ping.json",
 	}
 
-	err := Execute(opt)
+	_, err := Execute(opt)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
@@ -54,8 +57,58 @@ func TestExecuteImports(t *testing.T) {
 		Final:          "",
 	}
 
-	err := Execute(opt)
+	_, err := Execute(opt)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 }
+func TestExecuteSummary(t *testing.T) {
+	summary := filepath.Join(t.TempDir(), "summary.json")
+	opt := &config.GOptions{
+		Template: "test/base/base.json",
+		Configs: []string{
+			"test/base/ping.json",
+			"test/client/sequence.json",
+		},
+		HTTPServerCfg: "test/server/server.json",
+		Summary:       summary,
+	}
+
+	res, err := Execute(opt)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !res.Success || len(res.Configs) != 2 || res.End.Before(res.Start) {
+		t.Fatalf("invalid result: %+v", res)
+	}
+	c := res.Configs[0]
+	if c.Name != "ping" || !c.Success || len(c.Schedules) != 1 || c.DB["PONG"] != "1" {
+		t.Fatalf("invalid config result: %+v", c)
+	}
+	c = res.Configs[1]
+	if c.Name != "sequence-book" || !c.Success || len(c.Schedules) != 1 {
+		t.Fatalf("invalid config result: %+v", c)
+	}
+	s := c.Schedules[0]
+	if s.Name != "Sequence" || !s.Success || s.Passed != 3 || s.Failed != 0 || s.Global[KeySchedule] != s.Name {
+		t.Fatalf("invalid schedule result: %+v", s)
+	}
+	for i, name := range []string{"add", "query", "del"} {
+		tr := s.Tests[i]
+		if tr.Name != name || tr.Passed != 1 || tr.Status[200] != 1 || tr.Count != 1 {
+			t.Fatalf("invalid test result: %+v", tr)
+		}
+	}
+
+	b, err := ioutil.ReadFile(summary)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var saved config.Result
+	if err = json.Unmarshal(b, &saved); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !saved.Success || len(saved.Configs) != 2 || saved.Configs[1].Schedules[0].Tests[2].Name != "del" {
+		t.Fatalf("invalid summary: %s", string(b))
+	}
+}
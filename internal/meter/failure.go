@@ -0,0 +1,65 @@
+package meter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// failure classes of a request
+const (
+	failConnRefused = "conn_refused" // connection refused
+	failTimeout     = "timeout"      // HTTP execution timeout
+	failDNS         = "dns"          // domain name resolving fails
+	failTLS         = "tls"          // TLS handshake or certificate verification fails
+	failRequest     = "request"      // other HTTP execution failures
+	failRedirect    = "redirect"     // redirect limit is reached
+	failBody        = "read_body"    // reading response body fails
+	failTemplate    = "template"     // response does not match template
+	failCheck       = "check"        // response check fails
+	failResponse    = "response"     // other response processing failures
+)
+
+// redirectLimitError is reported if a request is redirected more than its limit.
+type redirectLimitError int
+
+func (e redirectLimitError) Error() string {
+	return fmt.Sprintf("stopped after %d redirects", int(e))
+}
+
+// classifyError returns failure class of an error reported by HTTP execution.
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var record tls.RecordHeaderError
+	var redirect redirectLimitError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &redirect):
+		return failRedirect
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return failConnRefused
+	case errors.As(err, &dnsErr):
+		return failDNS
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostname),
+		errors.As(err, &invalid), errors.As(err, &record):
+		return failTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return failTimeout
+	case strings.Contains(err.Error(), "tls: "), strings.Contains(err.Error(), "x509: "):
+		return failTLS
+	}
+	return failRequest
+}
@@ -0,0 +1,79 @@
+package meter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	// a closed port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	closed := "http://" + l.Addr().String()
+	_ = l.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secure.Close()
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	for _, c := range []struct {
+		url    string
+		expect string
+	}{
+		{closed, failConnRefused},
+		{slow.URL, failTimeout},
+		{secure.URL, failTLS},
+	} {
+		_, err := client.Get(c.url)
+		if class := classifyError(err); class != c.expect {
+			t.Fatalf("%s expect %s get %s: %v", c.url, c.expect, class, err)
+		}
+	}
+
+	dns := &url.Error{Op: "Get", URL: "http://a.invalid", Err: &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: &net.DNSError{Err: "no such host", Name: "a.invalid"},
+	}}
+	if class := classifyError(dns); class != failDNS {
+		t.Fatalf("expect dns get %s", class)
+	}
+}
+
+func TestFailureClasses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"a": 1}`))
+	}))
+	defer srv.Close()
+
+	res, err := runFixture(t, "classes.json", srv.URL)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	s := res.Schedules[0]
+	if s.Local["CLASSES"] != "ok" {
+		t.Fatalf("post process fails: %+v", s.Local)
+	}
+	if s.Classes["template"] != 2 || s.Classes["check"] != 2 || s.Status[404] != 2 {
+		t.Fatalf("invalid schedule result: %+v", s.PerfStat)
+	}
+	missing := s.Tests[2]
+	if missing.Name != "missing" || missing.Classes["check"] != 2 || missing.Failures[0].Class != failCheck {
+		t.Fatalf("invalid test result: %+v", missing)
+	}
+}
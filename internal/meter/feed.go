@@ -29,8 +29,15 @@ type baby struct {
 	c   content
 	err error
 }
+
+// feedSource composes an element of content
+type feedSource interface {
+	composable
+	iterable() bool
+}
+
 type dynamicFeeder struct {
-	source     map[string]segments
+	source     map[string]feedSource
 	c          chan *baby
 	seq        uint64
 	count      uint64
@@ -54,6 +61,11 @@ func (f *dynamicFeeder) feed(bg *background) (content, error) {
 	return b.c, b.err
 }
 
+// isCategory tells if c is an explicit category instead of a header.
+func isCategory(c category) bool {
+	return c == catMethod || c == catURL || c == catBody
+}
+
 func (f *dynamicFeeder) full() bool {
 	if f.end {
 		return true
@@ -110,26 +122,55 @@ func (f *dynamicFeeder) run() {
 
 			b.c[k] = str
 		}
+
+		// any other source is a header, which is composed for each request too
+		for k, s := range f.source {
+			if b.err != nil {
+				break
+			}
+			if isCategory(category(k)) {
+				continue
+			}
+
+			str, err := s.compose(b.bg)
+			if err != nil {
+				b.err = err
+				if isEof(err) {
+					f.end = true
+				}
+				break
+			}
+
+			b.c[category(k)] = str
+		}
 		b.wg.Done()
 	}
 }
 
 func makeDynamicFeeder(cfg map[string]string, count uint64, preprocess interface{}) (feeder, error) {
+	source := make(map[string]feedSource)
+	for k, v := range cfg {
+		if s, err := makeSegments(v); err != nil {
+			return nil, err
+		} else {
+			source[k] = s
+		}
+	}
+	return makeSourceFeeder(source, count, preprocess)
+}
+
+// makeSourceFeeder creates a feeder composing content from source.
+func makeSourceFeeder(source map[string]feedSource, count uint64, preprocess interface{}) (feeder, error) {
 	f := &dynamicFeeder{
-		source: make(map[string]segments),
+		source: source,
 		c:      make(chan *baby),
 		count:  count,
 	}
 
 	iterable := false
-	for k, v := range cfg {
-		if s, err := makeSegments(v); err != nil {
-			return nil, err
-		} else {
-			f.source[k] = s
-			if s.iterable() {
-				iterable = true
-			}
+	for _, s := range source {
+		if s.iterable() {
+			iterable = true
 		}
 	}
 
@@ -47,6 +47,35 @@ func TestDynamicFeed(t *testing.T) {
 		t.Fatalf(err.Error())
 	}
 }
+func TestDynamicFeedHeaders(t *testing.T) {
+	def := map[string]string{
+		string(catURL):  "http://127.0.0.1",
+		string(catBody): `{"seq": 0}`,
+		"X-Token":       "abc",
+		"X-Length":      "`strlen abcde`",
+	}
+	f, err := makeDynamicFeeder(def, 2, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	bg, err := makeBackground(nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// headers are composed for each request along with method, url and body
+	for i := 0; i < 2; i++ {
+		c, err := f.feed(bg)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(c) != 5 || c["X-Token"] != "abc" || c["X-Length"] != "5" ||
+			c[catMethod] != "GET" || c[catURL] != def[string(catURL)] || c[catBody] != def[string(catBody)] {
+			t.Fatalf("unexpected content %v", c)
+		}
+	}
+}
 func TestDynamicFeedDefaultMethod(t *testing.T) {
 	def := map[string]string{
 		string(catURL):  "http://127.0.0.1",
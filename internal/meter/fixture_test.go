@@ -0,0 +1,43 @@
+package meter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+// fixtureDir holds config fixtures, which are also read by readExample of
+// start_test.go, an external test package that could not call runConfig.
+var fixtureDir = filepath.Join("test", "start")
+
+// loadFixture reads config fixture name, default host "-" is set to url if url
+// is not empty, and relative paths are related to fixture directory.
+func loadFixture(t *testing.T, name string, url string) *config.Config {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(fixtureDir, name))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cfg := &config.Config{}
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(url) > 0 {
+		cfg.Hosts["-"].Host = url
+	}
+	if cfg.Options == nil {
+		cfg.Options = make(map[config.Option]string)
+	}
+	cfg.Options[config.OptionCfgPath] = fixtureDir
+	return cfg
+}
+
+// runFixture runs config fixture name with default host "-" set to url.
+func runFixture(t *testing.T, name string, url string) (*config.ConfigResult, error) {
+	t.Helper()
+	return runConfig(loadFixture(t, name, url))
+}
@@ -3,71 +3,118 @@ package meter
 import (
 	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// errStopped is returned if a request is not sent because plan stops.
+var errStopped = errors.New("plan stops")
+
 type passport interface {
 	cancel()
 }
+
+// flowControl limits QPS by a token bucket and limits parallel requests.
+//
+// Bucket holds at most burst tokens, and is refilled by qps tokens per second.
+// Each request takes one token, and if there is no token left, it reserves a
+// future token and sleeps until then, so requests are paced precisely instead
+// of being sent in bursts.
 type flowControl struct {
-	qps      int
+	qps      float64
+	burst    float64
+	tokens   float64
+	last     time.Time
 	parallel int
 	now      int
-	recent   int
-	t        time.Time
 	mt       sync.Mutex
+	cond     *sync.Cond
 }
 
 func (fc *flowControl) cancel() {
 	fc.mt.Lock()
 	fc.now--
 	fc.mt.Unlock()
+	fc.cond.Signal()
 }
 
-func (fc *flowControl) wait() passport {
-	for {
-		p := fc.try()
-		if p == nil {
-			time.Sleep(10 * time.Millisecond)
-		} else {
-			return p
+// refill should be called with lock held
+func (fc *flowControl) refill(now time.Time) {
+	if fc.qps > 0 {
+		fc.tokens += now.Sub(fc.last).Seconds() * fc.qps
+		if fc.tokens > fc.burst {
+			fc.tokens = fc.burst
 		}
 	}
+	fc.last = now
 }
 
-func (fc *flowControl) try() passport {
+// reserve takes a token and returns how long to wait before that token is available.
+func (fc *flowControl) reserve() time.Duration {
 	fc.mt.Lock()
 	defer fc.mt.Unlock()
 
-	if fc.parallel > 1 {
-		if fc.now >= fc.parallel {
-			return nil
-		}
+	if fc.qps <= 0 {
+		return 0
 	}
-	if fc.qps > 1 {
-		now := time.Now()
-		if now.Sub(fc.t) > time.Second {
-			fc.recent = 0
-			fc.t = now
-		}
-		if fc.recent >= fc.qps {
-			return nil
-		}
-		fc.recent++
+	fc.refill(time.Now())
+	fc.tokens--
+	if fc.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-fc.tokens / fc.qps * float64(time.Second))
+}
+
+// unreserve gives back a token taken by reserve.
+func (fc *flowControl) unreserve() {
+	fc.mt.Lock()
+	fc.tokens++
+	fc.mt.Unlock()
+}
+
+// wait waits for a token and a parallel slot, caller should cancel passport after
+// request is done. It returns false if quit is closed before token is available,
+// and the token is given back.
+func (fc *flowControl) wait(quit <-chan struct{}) (passport, bool) {
+	if du := fc.reserve(); du > 0 && !pause(du, quit) {
+		fc.unreserve()
+		return nil, false
 	}
 
+	fc.mt.Lock()
+	if fc.parallel > 1 {
+		for fc.now >= fc.parallel {
+			fc.cond.Wait()
+		}
+	}
 	fc.now++
-	//if fc.now%10 == 0 || fc.recent%10 == 0 {
-	//	fmt.Printf("qps %d parallel %d, now %d recent %d\n", fc.qps, fc.parallel, fc.now, fc.recent)
-	//}
-	return fc
+	fc.mt.Unlock()
+	return fc, true
 }
 
-func makeFlowControl(qps, parallel int) *flowControl {
-	return &flowControl{
+// setQPS changes QPS limit while running, qps not greater than 0 disables QPS limit.
+func (fc *flowControl) setQPS(qps float64) {
+	fc.mt.Lock()
+	fc.refill(time.Now())
+	fc.qps = qps
+	fc.mt.Unlock()
+}
+
+// makeFlowControl creates a flow control, qps not greater than 0 disables QPS limit,
+// burst less than 1 will be set to 1, and parallel less than 2 disables parallel limit.
+func makeFlowControl(qps float64, burst int, parallel int) *flowControl {
+	if burst < 1 {
+		burst = 1
+	}
+	fc := &flowControl{
 		qps:      qps,
+		burst:    float64(burst),
+		tokens:   float64(burst),
 		parallel: parallel,
 		now:      0,
 		mt:       sync.Mutex{},
-		t:        time.Now(),
+		last:     time.Now(),
 	}
+	fc.cond = sync.NewCond(&fc.mt)
+	return fc
 }
@@ -0,0 +1,120 @@
+package meter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// wait waits for fc without quit and cancels passport at once
+func wait(fc *flowControl) {
+	p, _ := fc.wait(nil)
+	p.cancel()
+}
+
+func TestFlowControlQPS(t *testing.T) {
+	fc := makeFlowControl(200, 1, 0)
+	start := time.Now()
+	for i := 0; i < 101; i++ {
+		wait(fc)
+	}
+	// first token is in bucket, and another 100 tokens takes 500ms
+	if du := time.Since(start); du < 480*time.Millisecond || du > 600*time.Millisecond {
+		t.Fatalf("expect about 500ms, get %v", du)
+	}
+}
+func TestFlowControlFraction(t *testing.T) {
+	fc := makeFlowControl(2.5, 1, 0)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		wait(fc)
+	}
+	if du := time.Since(start); du < 780*time.Millisecond || du > 900*time.Millisecond {
+		t.Fatalf("expect about 800ms, get %v", du)
+	}
+}
+func TestFlowControlBurst(t *testing.T) {
+	fc := makeFlowControl(10, 5, 0)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		wait(fc)
+	}
+	if du := time.Since(start); du > 10*time.Millisecond {
+		t.Fatalf("expect burst without waiting, get %v", du)
+	}
+	wait(fc)
+	if du := time.Since(start); du < 90*time.Millisecond {
+		t.Fatalf("expect waiting after burst, get %v", du)
+	}
+}
+func TestFlowControlParallel(t *testing.T) {
+	fc := makeFlowControl(0, 0, 3)
+	var mtx sync.Mutex
+	running, max := 0, 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, _ := fc.wait(nil)
+			mtx.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mtx.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mtx.Lock()
+			running--
+			mtx.Unlock()
+			p.cancel()
+		}()
+	}
+	wg.Wait()
+	if max != 3 {
+		t.Fatalf("expect 3 parallel, get %d", max)
+	}
+}
+func TestFlowControlQuit(t *testing.T) {
+	fc := makeFlowControl(0.5, 1, 0)
+	wait(fc)
+	quit := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		close(quit)
+	})
+	start := time.Now()
+	if _, ok := fc.wait(quit); ok {
+		t.Fatalf("expect quit while waiting")
+	}
+	if du := time.Since(start); du > 500*time.Millisecond {
+		t.Fatalf("expect waiting ends once quit, get %v", du)
+	}
+	// reservation is canceled, so next token is still 2s later than first one
+	if du := fc.reserve(); du < 1500*time.Millisecond || du > 2*time.Second {
+		t.Fatalf("expect reservation given back, next token after %v", du)
+	}
+}
+func TestFlowControlDuration(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// the second request waits 2s for its token, but schedule stops before it
+	cfg := loadFixture(t, "response.json", srv.URL)
+	cfg.Schedules[0].Tests = "plain"
+	cfg.Schedules[0].Count = 10
+	cfg.Schedules[0].QPS = 0.5
+	cfg.Schedules[0].Duration = "300ms"
+	start := time.Now()
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	if du := time.Since(start); du > time.Second {
+		t.Fatalf("expect schedule stops after duration, get %v", du)
+	}
+	if !cr.Success {
+		t.Fatalf("expect success, get %+v", cr)
+	}
+}
@@ -0,0 +1,109 @@
+package meter
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+const (
+	// values are grouped by power of 2, each group is split into histSubBuckets
+	// linear buckets, so relative error of any recorded value is less than
+	// 1/histSubBuckets.
+	histSubBits    = 5
+	histSubBuckets = 1 << histSubBits
+	histBuckets    = histSubBuckets + (64-histSubBits)*histSubBuckets
+)
+
+// histogram is an HDR-style log-linear histogram of non-negative values
+type histogram struct {
+	counts []int64
+	count  int64
+	total  int64
+	min    int64
+	max    int64
+}
+
+func histIndex(v int64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	e := bits.Len64(uint64(v)) - 1
+	shift := e - histSubBits
+	sub := int(v>>uint(shift)) - histSubBuckets
+	return histSubBuckets + shift*histSubBuckets + sub
+}
+
+// histRange returns value range of bucket idx
+func histRange(idx int) (from, to int64) {
+	if idx < histSubBuckets {
+		return int64(idx), int64(idx)
+	}
+	shift := (idx - histSubBuckets) / histSubBuckets
+	sub := (idx - histSubBuckets) % histSubBuckets
+	from = int64(histSubBuckets+sub) << uint(shift)
+	to = from + (int64(1) << uint(shift)) - 1
+	return
+}
+
+func (h *histogram) record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if h.counts == nil {
+		h.counts = make([]int64, histBuckets)
+	}
+	h.counts[histIndex(v)]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.total += v
+}
+
+// percentile returns the value that p percent of recorded values are less than or
+// equal to, p is in range (0, 100].
+func (h *histogram) percentile(p float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, c := range h.counts {
+		n += c
+		if n >= rank {
+			_, to := histRange(i)
+			if to > h.max {
+				to = h.max
+			}
+			return to
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) avg() int64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.total / h.count
+}
+
+// buckets returns all non-empty buckets in ascending order
+func (h *histogram) buckets() []config.Bucket {
+	var ret []config.Bucket
+	for i, c := range h.counts {
+		if c > 0 {
+			from, to := histRange(i)
+			ret = append(ret, config.Bucket{From: from, To: to, Count: c})
+		}
+	}
+	return ret
+}
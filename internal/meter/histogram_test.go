@@ -0,0 +1,59 @@
+package meter
+
+import (
+	"testing"
+)
+
+func TestHistogramRange(t *testing.T) {
+	for _, v := range []int64{0, 1, 31, 32, 33, 63, 64, 65, 1000, 123456, 1 << 40} {
+		idx := histIndex(v)
+		from, to := histRange(idx)
+		if v < from || v > to {
+			t.Fatalf("value %d not in bucket %d [%d, %d]", v, idx, from, to)
+		}
+		if v >= histSubBuckets && float64(to-from+1)/float64(v) > 1.0/histSubBuckets {
+			t.Fatalf("value %d bucket [%d, %d] too wide", v, from, to)
+		}
+	}
+}
+func TestHistogramPercentile(t *testing.T) {
+	h := &histogram{}
+	if h.percentile(50) != 0 {
+		t.Fatalf("expect 0 for empty histogram")
+	}
+	for i := int64(1); i <= 10000; i++ {
+		h.record(i)
+	}
+	if h.min != 1 || h.max != 10000 || h.avg() != 5000 {
+		t.Fatalf("min %d max %d avg %d", h.min, h.max, h.avg())
+	}
+	cases := []struct {
+		p      float64
+		expect int64
+	}{
+		{50, 5000},
+		{90, 9000},
+		{99, 9900},
+		{99.9, 9990},
+		{100, 10000},
+	}
+	for _, c := range cases {
+		v := h.percentile(c.p)
+		if v < c.expect || float64(v-c.expect) > float64(c.expect)/histSubBuckets {
+			t.Fatalf("p%v expect %d get %d", c.p, c.expect, v)
+		}
+	}
+
+	var total int64
+	last := int64(-1)
+	for _, b := range h.buckets() {
+		if b.From <= last || b.To < b.From {
+			t.Fatalf("invalid bucket %+v", b)
+		}
+		last = b.To
+		total += b.Count
+	}
+	if total != 10000 {
+		t.Fatalf("expect 10000 values in buckets, get %d", total)
+	}
+}
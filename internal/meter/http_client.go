@@ -57,3 +57,35 @@ func createConcurrentHttpClientWrapper(creator func() *http.Client) httpcFactory
 	}
 }
 
+
+// routineHttpcWrapper marks that each routine should use a dedicated connection
+// pool, see background.routineClient.
+type routineHttpcWrapper struct {
+	httpcFactory
+}
+
+// routineClient returns a client sharing everything with client except that it
+// has a dedicated transport for this routine.
+func (bg *background) routineClient(client *http.Client) *http.Client {
+	if c, ok := bg.clients[client]; ok {
+		return c
+	}
+	c := *client
+	if t, ok := c.Transport.(*http.Transport); ok {
+		c.Transport = t.Clone()
+	}
+	if bg.clients == nil {
+		bg.clients = make(map[*http.Client]*http.Client)
+	}
+	bg.clients[client] = &c
+	return &c
+}
+
+// closeClients closes idle connections of all routine clients of bg and drops
+// them, it should be called once the routine is done.
+func (bg *background) closeClients() {
+	for _, c := range bg.clients {
+		c.CloseIdleConnections()
+	}
+	bg.clients = nil
+}
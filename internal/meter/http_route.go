@@ -6,10 +6,12 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 
 	"github.com/forrestjgq/gmeter/config"
 )
@@ -26,6 +28,74 @@ type route struct {
 	src       *background
 	request   *dynamicConsumer
 	responses map[string]composable
+	websocket bool
+}
+
+var upgrader = websocket.Upgrader{}
+
+// serveWebSocket processes each message received on a WebSocket connection as
+// a request, and replies with response if any.
+func (rt *route) serveWebSocket(w http.ResponseWriter, r *http.Request, bg *background) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader has replied with error
+		return
+	}
+	defer conn.Close()
+
+	for {
+		typ, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		bg.setLocalEnv(KeyRequest, string(b))
+		bg.delLocalEnv(KeyResponse)
+		bg.setError(nil)
+
+		if rt.request != nil {
+			// failure is ignored by request consumer, while error is kept
+			_ = rt.request.process(bg, KeyRequest)
+			if bg.hasError() {
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation,
+					fmt.Sprintf("invalid request: %v", bg.getError()))
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				return
+			}
+		}
+
+		s, err := rt.response(bg)
+		if err != nil {
+			msg := websocket.FormatCloseMessage(websocket.CloseInternalServerErr,
+				fmt.Sprintf("response compose fail: %v", err))
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			return
+		}
+		if len(s) > 0 {
+			if err = conn.WriteMessage(typ, []byte(s)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// response composes response chosen by $(RESPONSE), or the only one if it is
+// empty. Empty string is returned if no response is chosen.
+func (rt *route) response(bg *background) (string, error) {
+	rsp := bg.getLocalEnv(KeyResponse)
+	if len(rsp) == 0 {
+		if len(rt.responses) == 1 {
+			for k := range rt.responses {
+				rsp = k
+			}
+		}
+	}
+	if len(rsp) > 0 {
+		crsp := rt.responses[rsp]
+		if crsp != nil {
+			return crsp.compose(bg)
+		}
+	}
+	return "", nil
 }
 
 func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +124,11 @@ func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	bg.setLocalEnv(KeyURL, r.URL.String())
 
+	if rt.websocket {
+		rt.serveWebSocket(w, r, bg)
+		return
+	}
+
 	b, err := ioutil.ReadAll(r.Body)
 	if err == nil && len(b) > 0 {
 		bg.setLocalEnv(KeyRequest, string(b))
@@ -106,32 +181,21 @@ func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(sti)
 
-	rsp := bg.getLocalEnv(KeyResponse)
-	if len(rsp) == 0 {
-		if len(rt.responses) == 1 {
-			for k := range rt.responses {
-				rsp = k
-			}
-		}
-	}
-	if len(rsp) > 0 {
-		crsp := rt.responses[rsp]
-		if crsp != nil {
-			s, err := crsp.compose(bg)
-			if err != nil {
-				w.WriteHeader(500)
-				_, _ = w.Write([]byte(fmt.Sprintf("response compose fail: %+v", err)))
-				return
-			}
-			_, _ = w.Write([]byte(s))
-		}
+	s, err := rt.response(bg)
+	if err != nil {
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte(fmt.Sprintf("response compose fail: %+v", err)))
+		return
 	}
+	_, _ = w.Write([]byte(s))
 }
 func makeRoute(src *background, cfg *config.Route) (http.Handler, error) {
 	r := &route{
+		cfg:       *cfg,
 		headers:   make(map[string]*header),
 		src:       src,
 		responses: make(map[string]composable),
+		websocket: cfg.WebSocket,
 	}
 
 	var err error
@@ -149,11 +213,14 @@ func makeRoute(src *background, cfg *config.Route) (http.Handler, error) {
 		if s.isStatic() {
 			h.static = true
 		}
+		r.headers[k] = h
 	}
 
-	r.request, err = makeDynamicConsumer(cfg.Request.Check, cfg.Request.Success, cfg.Request.Failure, cfg.Request.Template, ignoreOnFail)
-	if err != nil {
-		return nil, errors.Wrapf(err, "make request consumer")
+	if cfg.Request != nil {
+		r.request, err = makeDynamicConsumer(cfg.Request.Check, cfg.Request.Success, cfg.Request.Failure, cfg.Request.Template, ignoreOnFail)
+		if err != nil {
+			return nil, errors.Wrapf(err, "make request consumer")
+		}
 	}
 
 	for k, v := range cfg.Response {
@@ -0,0 +1,78 @@
+package meter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestRoute(t *testing.T) {
+	c := &config.HttpServers{
+		Servers: map[string]*config.HttpServer{
+			"route": {
+				Address: "127.0.0.1:0",
+				Routes: []*config.Route{
+					{
+						// no Request defined
+						Path: "/env",
+						Env:  map[string]string{"NAME": "gmeter"},
+						Response: map[string]json.RawMessage{
+							"name": json.RawMessage(`"$(NAME)"`),
+						},
+					},
+					{
+						Path: "/header",
+						Headers: map[string]string{
+							"X-Key": "Secret",
+						},
+					},
+				},
+			},
+		},
+	}
+	err := StartHTTPServerConfig(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer StopAll()
+
+	url := "http://127.0.0.1:" + strconv.Itoa(servers["route"].port)
+	get := func(path, key string) (int, string) {
+		req, err := http.NewRequest("GET", url+path, nil)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(key) > 0 {
+			req.Header.Set("X-Key", key)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		defer func() {
+			_ = rsp.Body.Close()
+		}()
+		b, err := ioutil.ReadAll(rsp.Body)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		return rsp.StatusCode, string(b)
+	}
+
+	if st, body := get("/env", ""); st != 200 || body != `"gmeter"` {
+		t.Errorf("expect route env in response, got %d %s", st, body)
+	}
+	if st, _ := get("/header", "secret"); st != 200 {
+		t.Errorf("expect header match, got %d", st)
+	}
+	if st, _ := get("/header", "other"); st != 400 {
+		t.Errorf("expect header mismatch, got %d", st)
+	}
+	if st, _ := get("/header", ""); st != 400 {
+		t.Errorf("expect header missing, got %d", st)
+	}
+}
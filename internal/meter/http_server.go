@@ -62,7 +62,10 @@ func (s *httpsrv) start(name string, cfg *config.HttpServer) error {
 		if err != nil {
 			return errors.Wrapf(err, "make route %d", i)
 		}
-		s.r.Methods(method).Path(rc.Path).Handler(f)
+		s.r.Methods(method).Path(rc.Path).Handler(promHandler(name, method+" "+rc.Path, f))
+	}
+	if len(cfg.Metrics) > 0 {
+		s.r.Methods("GET").Path(cfg.Metrics).Handler(prom)
 	}
 	seg, err := makeSegments(cfg.Address)
 	if err != nil {
@@ -0,0 +1,161 @@
+package meter
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+// JUnit XML elements, see https://llg.cubic.org/docs/junit/
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Content string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name         `xml:"testsuite"`
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Time      string           `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+func junitTime(du time.Duration) string {
+	return fmt.Sprintf("%.3f", du.Seconds())
+}
+
+// junitFailureContent formats a failed request as content of failure element
+func junitFailureContent(f *config.Failure) string {
+	var sb strings.Builder
+	sb.WriteString("FAILURE: " + f.Message + "\n")
+	sb.WriteString("URL: " + f.URL + "\n")
+	sb.WriteString("Request: " + f.Request + "\n")
+	if len(f.Status) > 0 {
+		sb.WriteString("Status: " + f.Status + "\n")
+		sb.WriteString("Response: " + f.Response + "\n")
+	}
+	return sb.String()
+}
+
+// add appends a test case to suite and counts it.
+func (s *junitTestSuite) add(tc *junitTestCase) {
+	s.TestCases = append(s.TestCases, tc)
+	s.Tests++
+	if tc.Failure != nil {
+		s.Failures++
+	}
+	if tc.Error != nil {
+		s.Errors++
+	}
+}
+
+// makeJUnitSuite converts a config result to a test suite. Each schedule is a test
+// case which fails if schedule aborts, and each test of schedule is a test case
+// which fails if any request of it fails.
+func makeJUnitSuite(c *config.ConfigResult) *junitTestSuite {
+	s := &junitTestSuite{
+		Name:      c.Name,
+		Time:      junitTime(c.End.Sub(c.Start)),
+		Timestamp: c.Start.Format("2006-01-02T15:04:05"),
+	}
+	if len(s.Name) == 0 {
+		s.Name = c.Path
+	}
+
+	if len(c.Schedules) == 0 && !c.Success {
+		// config fails before any schedule runs
+		s.add(&junitTestCase{
+			Name:      s.Name,
+			ClassName: s.Name,
+			Time:      s.Time,
+			Error:     &junitFailure{Message: c.Error, Content: c.Error},
+		})
+	}
+
+	for _, sched := range c.Schedules {
+		tc := &junitTestCase{
+			Name:      sched.Name,
+			ClassName: s.Name,
+			Time:      junitTime(sched.End.Sub(sched.Start)),
+		}
+		if !sched.Success {
+			tc.Failure = &junitFailure{Message: sched.Error, Type: "schedule", Content: sched.Error}
+		}
+		s.add(tc)
+
+		for _, t := range sched.Tests {
+			tc := &junitTestCase{
+				Name:      t.Name,
+				ClassName: s.Name + "." + sched.Name,
+				Time:      junitTime(time.Duration(t.Avg*t.Count) * time.Microsecond),
+				SystemOut: fmt.Sprintf("requests %d passed %d failed %d", t.Requests, t.Passed, t.Failed),
+			}
+			if len(t.Failures) > 0 {
+				content := make([]string, 0, len(t.Failures))
+				for _, f := range t.Failures {
+					content = append(content, junitFailureContent(f))
+				}
+				tc.Failure = &junitFailure{
+					Message: fmt.Sprintf("%d of %d requests failed: %s", t.Failed, t.Requests, t.Failures[0].Message),
+					Type:    t.Failures[0].Class,
+					Content: strings.Join(content, "\n"),
+				}
+			}
+			s.add(tc)
+		}
+	}
+	return s
+}
+
+// writeJUnit writes result to path as JUnit XML, each config is a test suite.
+func writeJUnit(path string, res *config.Result) error {
+	suites := &junitTestSuites{
+		Name: "gmeter",
+		Time: junitTime(res.End.Sub(res.Start)),
+	}
+	for _, c := range res.Configs {
+		s := makeJUnitSuite(c)
+		suites.Suites = append(suites.Suites, s)
+		suites.Tests += s.Tests
+		suites.Failures += s.Failures
+		suites.Errors += s.Errors
+	}
+
+	b, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), b...), 0666)
+}
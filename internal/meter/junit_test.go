@@ -0,0 +1,76 @@
+package meter
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestJUnit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cr, err := runFixture(t, "junit.json", srv.URL)
+	if err == nil {
+		t.Fatalf("expect schedule fail")
+	}
+	res := &config.Result{
+		Start:   cr.Start,
+		End:     time.Now(),
+		Configs: []*config.ConfigResult{cr, {Name: "bad", Error: "load config"}},
+	}
+
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	if err = writeJUnit(path, res); err != nil {
+		t.Fatalf(err.Error())
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var suites junitTestSuites
+	if err = xml.Unmarshal(b, &suites); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// pass, pass.ping, fail, fail.ping, fail.missing, bad
+	if len(suites.Suites) != 2 || suites.Tests != 6 || suites.Failures != 2 || suites.Errors != 1 {
+		t.Fatalf("invalid suites: %s", string(b))
+	}
+
+	s := suites.Suites[0]
+	if s.Name != "junit" || len(s.TestCases) != 5 {
+		t.Fatalf("invalid suite: %s", string(b))
+	}
+	for i, c := range []struct {
+		name, class string
+		fail        bool
+	}{
+		{"pass", "junit", false},
+		{"ping", "junit.pass", false},
+		{"fail", "junit", true},
+		{"ping", "junit.fail", false},
+		{"missing", "junit.fail", true},
+	} {
+		tc := s.TestCases[i]
+		if tc.Name != c.name || tc.ClassName != c.class || (tc.Failure != nil) != c.fail {
+			t.Fatalf("invalid test case %d: %+v", i, tc)
+		}
+	}
+	f := s.TestCases[4].Failure
+	if !strings.Contains(f.Content, "FAILURE: ") ||
+		!strings.Contains(f.Content, "URL: "+srv.URL+"/missing") ||
+		!strings.Contains(f.Content, "Status: 404") {
+		t.Fatalf("invalid failure: %s", f.Content)
+	}
+}
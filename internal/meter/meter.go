@@ -2,20 +2,19 @@ package meter
 
 import (
 	"io"
+	"net/http"
+	"net/http/cookiejar"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/forrestjgq/glog"
 
 	"github.com/forrestjgq/gmeter/config"
-	"github.com/forrestjgq/gomark"
 
 	"github.com/pkg/errors"
-
-	"github.com/forrestjgq/gomark/gmi"
 )
 
 type next int
@@ -43,10 +42,42 @@ const (
 	KeyRequest  = "REQUEST"
 	KeyStatus   = "STATUS"
 	KeyResponse = "RESPONSE"
+	KeyAttempt  = "ATTEMPT"
+	KeyBackend  = "BACKEND"
+	KeyExchange = "EXCHANGE" // index of WebSocket exchange, start from 1
+	KeyToken    = "TOKEN"    // bearer token sent
 	KeyInput    = "INPUT"
 	KeyOutput   = "OUTPUT"
 	KeyError    = "ERROR"
 
+	// response details
+	KeyHeader        = "HEADER." // prefix of response header, like HEADER.Content-Type
+	KeyProto         = "PROTO"
+	KeyContentLength = "CONTENT_LENGTH"
+	KeyTimeDNS       = "TIME.DNS"
+	KeyTimeConnect   = "TIME.CONNECT"
+	KeyTimeTLS       = "TIME.TLS"
+	KeyTimeTTFB      = "TIME.TTFB"
+	KeyTimeTotal     = "TIME.TOTAL"
+	KeyConnReused    = "CONN.REUSED" // "true" if request is sent on a reused connection
+	KeyRedirects     = "REDIRECTS"   // count of redirects followed
+	KeyRedirect      = "REDIRECT."   // prefix of redirect chain, like REDIRECT.0.URL, REDIRECT.0.STATUS
+
+	// streaming response
+	KeyEvent          = "EVENT"
+	KeyEventIndex     = "EVENT.INDEX" // index of event, start from 1
+	KeyEventType      = "EVENT.TYPE"  // SSE event type
+	KeyEventID        = "EVENT.ID"    // SSE event id
+	KeyEvents         = "EVENTS"      // count of events read
+	KeyStreamStop     = "STREAM.STOP" // write "true" to stop stream
+	KeyTimeFirstEvent = "TIME.FIRST_EVENT"
+
+	// sizes of body, wire size is the size of body compressed
+	KeyRequestSize      = "REQUEST.SIZE"
+	KeyRequestWireSize  = "REQUEST.WIRE_SIZE"
+	KeyResponseSize     = "RESPONSE.SIZE"
+	KeyResponseWireSize = "RESPONSE.WIRE_SIZE"
+
 	KeyFailure = "FAILURE"
 	EOF        = "EOF"
 )
@@ -149,6 +180,28 @@ func (db *kvdb) dup() env {
 
 var globaldb env
 
+// snapshot copies all variables in e, nil will be returned if e is empty or
+// can not be iterated.
+func snapshot(e env) map[string]string {
+	var m map[string]string
+	switch t := e.(type) {
+	case simpEnv:
+		m = t
+	case *kvdb:
+		t.mtx.Lock()
+		defer t.mtx.Unlock()
+		m = t.m
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	ret := make(map[string]string)
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
+}
+
 func createDB() env {
 	if globaldb == nil {
 		globaldb = &kvdb{
@@ -214,70 +267,6 @@ func makeArguments(args []string) (arguments, error) {
 	return a, nil
 }
 
-type perf struct {
-	lr           gmi.Marker
-	adder        gmi.Marker
-	cLatency     chan int32
-	maxLatency   int32
-	minLatency   int32
-	totalLatency int64
-	start        time.Time
-	count        int64
-}
-
-func (p *perf) close() {
-	if p.cLatency != nil {
-		close(p.cLatency)
-		p.cLatency = nil
-	}
-}
-
-func (p *perf) report(latency int32) {
-	if p.cLatency != nil {
-		p.cLatency <- latency
-	}
-}
-
-func (p *perf) commit() (max, min, avg int32, qps int64) {
-	if p.count == 0 {
-		return
-	}
-	max = p.maxLatency
-	min = p.minLatency
-	avg = int32(p.totalLatency / p.count)
-	du := time.Since(p.start).Milliseconds()
-	//fmt.Printf("count %d du %d ms", p.count, du)
-
-	if du > 0 {
-		qps = p.count * 1000 / du
-	}
-	return
-}
-
-func makePerf(name string) *perf {
-	p := &perf{
-		lr:       gomark.NewLatencyRecorder(name),
-		adder:    gomark.NewAdder(name + "_cnt"),
-		cLatency: make(chan int32, 1000),
-	}
-	go func() {
-		for lr := range p.cLatency {
-			if p.count == 0 {
-				p.start = time.Now()
-			}
-			p.count++
-			p.totalLatency += int64(lr)
-			if p.maxLatency == 0 || p.maxLatency < lr {
-				p.maxLatency = lr
-			}
-			if p.minLatency == 0 || p.minLatency > lr {
-				p.minLatency = lr
-			}
-		}
-	}()
-	return p
-}
-
 type background struct {
 	name              string // global test name
 	db, local, global env
@@ -289,6 +278,13 @@ type background struct {
 	fargs             [][]string // arguments stacks
 	functions         map[string]composable
 	perf              *perf
+	failClass         string   // failure class of current test, empty if not failed
+	rspEnv            []string // local variables of last response, like headers
+	cookie            bool     // if cookie jar is enabled
+	jar               http.CookieJar
+	clients           map[*http.Client]*http.Client // routine clients by shared client
+	backend           *backend                      // backend chosen for current test
+	quit              <-chan struct{}               // closed when plan stops, optional
 }
 
 func makeBackground(cfg *config.Config, sched *config.Schedule) (*background, error) {
@@ -311,6 +307,8 @@ func makeBackground(cfg *config.Config, sched *config.Schedule) (*background, er
 		if debug, ok := cfg.Options[config.OptionDebug]; ok {
 			bg.setGlobalEnv(KeyDebug, debug)
 		}
+		bg.cookie = cfg.Options[config.OptionCookieJar] == "true"
+		bg.resetJar()
 
 		for k, v := range cfg.Env {
 			if k != "" {
@@ -377,7 +375,7 @@ func (bg *background) globalClose() {
 	}
 }
 func (bg *background) dup() *background {
-	return &background{
+	n := &background{
 		name:      bg.name,
 		local:     bg.local.dup(),
 		global:    bg.global,
@@ -387,13 +385,19 @@ func (bg *background) dup() *background {
 		predefine: bg.predefine,
 		fc:        bg.fc,
 		functions: bg.functions,
+		cookie:    bg.cookie,
+		quit:      bg.quit,
 	}
+	n.resetJar()
+	return n
 }
 func (bg *background) next() {
 	bg.cleanup()
 }
 func (bg *background) cleanup() {
 	bg.local = make(simpEnv)
+	bg.rspEnv = nil
+	bg.resetJar()
 	if bg.predefine != nil {
 		for k, v := range bg.predefine {
 			bg.setLocalEnv(k, v)
@@ -401,6 +405,14 @@ func (bg *background) cleanup() {
 	}
 	bg.err = nil
 }
+
+// resetJar drops all cookies if cookie jar is enabled.
+func (bg *background) resetJar() {
+	if bg.cookie {
+		// cookiejar.New never fails without options
+		bg.jar, _ = cookiejar.New(nil)
+	}
+}
 func (bg *background) reportLatency(latency int32) {
 	if bg.perf != nil {
 		bg.perf.report(latency)
@@ -529,6 +541,9 @@ func (bg *background) getLocalEnv(key string) string {
 			v = bg.err.Error()
 		}
 	} else {
+		if strings.HasPrefix(k, KeyHeader) {
+			k = headerKey(k[len(KeyHeader):])
+		}
 		v = bg.local.get(k)
 	}
 	return calcSign(sign, v)
@@ -562,13 +577,38 @@ func (bg *background) inDebug() bool {
 	return bg.getGlobalEnv(KeyDebug) == "true"
 }
 
-func (bg *background) commit() {
+// commit writes latency statistics into local variables and returns it, nil
+// will be returned if there is no perf.
+func (bg *background) commit() *config.PerfStat {
 	if bg.perf != nil {
-		max, min, avg, qps := bg.perf.commit()
-		bg.setLocalEnv("_.latency.max", strconv.Itoa(int(max)))
-		bg.setLocalEnv("_.latency.min", strconv.Itoa(int(min)))
-		bg.setLocalEnv("_.latency.avg", strconv.Itoa(int(avg)))
-		bg.setLocalEnv("_.qps", strconv.Itoa(int(qps)))
+		st := bg.perf.commit()
+		bg.setLocalEnv("_.latency.max", strconv.FormatInt(st.Max, 10))
+		bg.setLocalEnv("_.latency.min", strconv.FormatInt(st.Min, 10))
+		bg.setLocalEnv("_.latency.avg", strconv.FormatInt(st.Avg, 10))
+		bg.setLocalEnv("_.latency.p50", strconv.FormatInt(st.P50, 10))
+		bg.setLocalEnv("_.latency.p90", strconv.FormatInt(st.P90, 10))
+		bg.setLocalEnv("_.latency.p95", strconv.FormatInt(st.P95, 10))
+		bg.setLocalEnv("_.latency.p99", strconv.FormatInt(st.P99, 10))
+		bg.setLocalEnv("_.latency.p999", strconv.FormatInt(st.P999, 10))
+		bg.setLocalEnv("_.qps", strconv.FormatInt(st.QPS, 10))
+		bg.setCountEnv("_.", st)
+		return st
+	}
+	return nil
+}
+
+// setCountEnv writes request, error, status and failure class counts of st into
+// local variables with prefix.
+func (bg *background) setCountEnv(prefix string, st *config.PerfStat) {
+	bg.setLocalEnv(prefix+"requests", strconv.FormatInt(st.Requests, 10))
+	bg.setLocalEnv(prefix+"errors", strconv.FormatInt(st.Errors, 10))
+	bg.setLocalEnv(prefix+"conns", strconv.FormatInt(st.Connections, 10))
+	bg.setLocalEnv(prefix+"reused", strconv.FormatInt(st.Reused, 10))
+	for k, v := range st.Status {
+		bg.setLocalEnv(prefix+"status."+strconv.Itoa(k), strconv.FormatInt(v, 10))
+	}
+	for k, v := range st.Classes {
+		bg.setLocalEnv(prefix+"error."+k, strconv.FormatInt(v, 10))
 	}
 }
 
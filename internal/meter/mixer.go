@@ -0,0 +1,86 @@
+package meter
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+// mixBranch is a test or test pipeline of a weighted mix.
+type mixBranch struct {
+	name   string
+	weight int
+	target runnable
+	picked int64
+}
+
+// mixer runs one of its branches picked randomly by weight for each iteration.
+// Tests of branches do not count, mixer finishes after count iterations instead.
+type mixer struct {
+	branches []*mixBranch
+	total    int    // sum of weights
+	count    uint64 // iterations to run, 0 for infinite
+	started  uint64
+}
+
+func (m *mixer) add(name string, weight int, target runnable) {
+	if weight == 0 {
+		weight = 1
+	}
+	m.branches = append(m.branches, &mixBranch{
+		name:   name,
+		weight: weight,
+		target: target,
+	})
+	m.total += weight
+}
+
+// pick chooses a branch by weight and counts it.
+func (m *mixer) pick() *mixBranch {
+	n := rand.Intn(m.total)
+	for _, b := range m.branches {
+		if n < b.weight {
+			atomic.AddInt64(&b.picked, 1)
+			return b
+		}
+		n -= b.weight
+	}
+	// never reach here
+	return nil
+}
+
+// implements runnable
+func (m *mixer) run(bg *background) next {
+	if m.count > 0 && atomic.AddUint64(&m.started, 1) > m.count {
+		return nextFinished
+	}
+	return m.pick().target.run(bg)
+}
+
+func (m *mixer) close() {
+	for _, b := range m.branches {
+		b.target.close()
+	}
+}
+
+// results reports observed ratio of each branch.
+func (m *mixer) results() []*config.MixResult {
+	var total int64
+	for _, b := range m.branches {
+		total += atomic.LoadInt64(&b.picked)
+	}
+	var ret []*config.MixResult
+	for _, b := range m.branches {
+		r := &config.MixResult{
+			Tests:  b.name,
+			Weight: b.weight,
+			Picked: atomic.LoadInt64(&b.picked),
+		}
+		if total > 0 {
+			r.Ratio = float64(r.Picked) / float64(total)
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
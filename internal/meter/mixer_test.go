@@ -0,0 +1,52 @@
+package meter
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestMix(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cfg := loadFixture(t, "mix.json", srv.URL)
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	sr := cr.Schedules[0]
+	if len(sr.Mix) != 3 {
+		t.Fatalf("expect 3 mix results, got %d", len(sr.Mix))
+	}
+	var picked int64
+	for _, m := range sr.Mix {
+		picked += m.Picked
+		if expect := float64(m.Weight) / 100; math.Abs(m.Ratio-expect) > 0.06 {
+			t.Errorf("mix %s ratio %v, expect %v", m.Tests, m.Ratio, expect)
+		}
+	}
+	if picked != 1000 {
+		t.Errorf("expect 1000 iterations picked, got %d", picked)
+	}
+
+	// a pipeline runs all its tests once it's picked
+	requests := make(map[string]int64)
+	for _, tr := range sr.Tests {
+		requests[tr.Name] = tr.PerfStat.Requests
+	}
+	if n := sr.Mix[2].Picked; requests["cart"] != n || requests["checkout"] != n || cr.DB["checkout"] != strconv.FormatInt(n, 10) {
+		t.Errorf("expect cart and checkout run %d times, got %d and %d", n, requests["cart"], requests["checkout"])
+	}
+	if requests["search"] != sr.Mix[0].Picked {
+		t.Errorf("expect search run %d times, got %d", sr.Mix[0].Picked, requests["search"])
+	}
+
+	cfg.Schedules[0].Tests = "search"
+	if _, err = loadPlan(cfg, cfg.Schedules[0]); err == nil {
+		t.Errorf("expect Tests and Mix exclusive")
+	}
+}
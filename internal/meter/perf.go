@@ -0,0 +1,217 @@
+package meter
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+	"github.com/forrestjgq/gomark"
+	"github.com/forrestjgq/gomark/gmi"
+)
+
+// perf records performance of a schedule or a test, and exposes it to gomark.
+type perf struct {
+	name     string
+	lr       gmi.Marker
+	adder    gmi.Marker // requests in flight
+	errAdder gmi.Marker
+	stAdders map[int]gmi.Marker
+	hist     histogram
+	requests int64
+	errors   int64
+	status   map[int]int64
+	classes  map[string]int64  // failure class -> count
+	failures []*config.Failure // first maxFailures failures
+	prom     *promSeries       // optional, test perf only
+	conns    int64             // requests sent on new connections
+	reused   int64             // requests sent on reused connections
+	start    time.Time
+	mtx      sync.Mutex
+}
+
+// maxFailures defines how many failure messages are kept in a perf
+const maxFailures = 10
+
+func (p *perf) close() {
+}
+
+// report records latency synchronously so that commit always sees all latencies
+// reported before.
+func (p *perf) report(latency int32) {
+	p.mtx.Lock()
+	if p.hist.count == 0 {
+		p.start = time.Now()
+	}
+	p.hist.record(int64(latency))
+	p.mtx.Unlock()
+}
+
+// mark records latency measured by others into gomark, prometheus and statistics
+func (p *perf) mark(latency int32) {
+	p.lr.Mark(latency)
+	if p.prom != nil {
+		p.prom.observe(int64(latency))
+	}
+	p.report(latency)
+}
+
+// enter marks a request is in flight
+func (p *perf) enter() {
+	p.adder.Mark(1)
+	if p.prom != nil {
+		p.prom.enter()
+	}
+}
+
+// leave marks a request is no longer in flight
+func (p *perf) leave() {
+	p.adder.Mark(-1)
+	if p.prom != nil {
+		p.prom.leave()
+	}
+}
+
+// record counts a finished request, status is 0 if server does not respond, and
+// f is not nil if request fails.
+func (p *perf) record(status int, f *config.Failure) {
+	if p.prom != nil {
+		class := ""
+		if f != nil {
+			class = f.Class
+		}
+		p.prom.record(status, class)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.requests++
+	if f != nil {
+		p.errors++
+		p.errAdder.Mark(1)
+		p.classes[f.Class]++
+		if len(p.failures) < maxFailures {
+			p.failures = append(p.failures, f)
+		}
+	}
+	if status > 0 {
+		p.status[status]++
+		m, ok := p.stAdders[status]
+		if !ok {
+			m = gomark.NewAdder(p.name + "_status_" + strconv.Itoa(status))
+			p.stAdders[status] = m
+		}
+		m.Mark(1)
+	}
+}
+
+// conn counts a request sent on a new or reused connection
+func (p *perf) conn(reused bool) {
+	p.mtx.Lock()
+	if reused {
+		p.reused++
+	} else {
+		p.conns++
+	}
+	p.mtx.Unlock()
+}
+
+func (p *perf) commit() *config.PerfStat {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	h := &p.hist
+	st := &config.PerfStat{
+		Requests:    p.requests,
+		Errors:      p.errors,
+		Connections: p.conns,
+		Reused:      p.reused,
+	}
+	if len(p.status) > 0 {
+		st.Status = make(map[int]int64)
+		for k, v := range p.status {
+			st.Status[k] = v
+		}
+	}
+	if len(p.classes) > 0 {
+		st.Classes = make(map[string]int64)
+		for k, v := range p.classes {
+			st.Classes[k] = v
+		}
+	}
+	if h.count == 0 {
+		return st
+	}
+	st.Count = h.count
+	st.Max = h.max
+	st.Min = h.min
+	st.Avg = h.avg()
+	st.P50 = h.percentile(50)
+	st.P90 = h.percentile(90)
+	st.P95 = h.percentile(95)
+	st.P99 = h.percentile(99)
+	st.P999 = h.percentile(99.9)
+	st.Buckets = h.buckets()
+	du := time.Since(p.start).Milliseconds()
+	if du > 0 {
+		st.QPS = h.count * 1000 / du
+	}
+	return st
+}
+
+// result commits perf as result of test name
+func (p *perf) result(name string) *config.TestResult {
+	st := p.commit()
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return &config.TestResult{
+		Name:     name,
+		Passed:   st.Requests - st.Errors,
+		Failed:   st.Errors,
+		Failures: append([]*config.Failure(nil), p.failures...),
+		PerfStat: st,
+	}
+}
+
+func makePerf(name string) *perf {
+	return &perf{
+		name:     name,
+		lr:       gomark.NewLatencyRecorder(name),
+		adder:    gomark.NewAdder(name + "_cnt"),
+		errAdder: gomark.NewAdder(name + "_err"),
+		stAdders: make(map[int]gmi.Marker),
+		status:   make(map[int]int64),
+		classes:  make(map[string]int64),
+	}
+}
+
+// statusString formats status distribution like "200:98 500:2" in status order
+func statusString(status map[int]int64) string {
+	var codes []int
+	for k := range status {
+		codes = append(codes, k)
+	}
+	sort.Ints(codes)
+	var s []string
+	for _, c := range codes {
+		s = append(s, strconv.Itoa(c)+":"+strconv.FormatInt(status[c], 10))
+	}
+	return strings.Join(s, " ")
+}
+
+// classString formats failure class counts like "check:2 timeout:1" in class order
+func classString(classes map[string]int64) string {
+	var names []string
+	for k := range classes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var s []string
+	for _, c := range names {
+		s = append(s, c+":"+strconv.FormatInt(classes[c], 10))
+	}
+	return strings.Join(s, " ")
+}
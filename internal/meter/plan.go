@@ -1,15 +1,52 @@
 package meter
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/forrestjgq/glog"
+
+	"github.com/forrestjgq/gmeter/config"
 )
 
+// stageInterval defines how often concurrency and QPS are adjusted while running stages
+const stageInterval = 100 * time.Millisecond
+
+// stage is a parsed config.Stage
+type stage struct {
+	du          time.Duration
+	concurrency int
+	qps         float64
+}
+
+// arrival is a parsed config.Arrival
+type arrival struct {
+	rate        float64
+	poisson     bool
+	maxInFlight int
+	drop        bool
+}
+
+// interval returns time to wait for next arrival
+func (a *arrival) interval() time.Duration {
+	sec := 1 / a.rate
+	if a.poisson {
+		sec = rand.ExpFloat64() / a.rate
+	}
+	return time.Duration(sec * float64(time.Second))
+}
+
 type plan struct {
 	name        string
 	target      runnable
@@ -19,24 +56,143 @@ type plan struct {
 	postprocess composable
 	seq         int64
 	fc          *flowControl
+	duration    time.Duration
+	stages      []stage
+	qps         float64
+	arrival     *arrival
+	perfReport  string                 // path to write latency statistics
+	stat        *config.PerfStat       // latency statistics after plan runs
+	tests       []string               // test names in running order, without duplication
+	testPerf    map[string]*perf       // test name -> perf
+	testResults []*config.TestResult   // test results after plan runs, in order of tests
+	balancers   []*balancer            // balancers of hosts with backends
+	lbResults   []*config.TestResult   // backend results after plan runs
+	mix         *mixer                 // weighted mix, optional
+	mixResults  []*config.MixResult    // mix results after plan runs
+	think       *thinkTime             // pause between iterations, optional
+	pacing      time.Duration          // interval of iterations of a routine, optional
+	quit        chan struct{}          // closed when plan stops to end pauses
+	quitOnce    sync.Once              // closes quit once
+	start, end  time.Time              // when plan starts and ends
+	err         error                  // first error that aborts plan
+	mtx         sync.Mutex             // protects err
+	result      *config.ScheduleResult // result after plan runs
+}
+
+// setError saves the first error that aborts plan.
+func (p *plan) setError(err error) {
+	p.mtx.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mtx.Unlock()
+}
+
+// halt ends pauses of all routines once plan stops.
+func (p *plan) halt() {
+	p.quitOnce.Do(func() {
+		if p.quit != nil {
+			close(p.quit)
+		}
+	})
+}
+
+// rest pauses a routine between iterations by think time and pacing, the last
+// iteration started at start. It returns false if plan stops.
+func (p *plan) rest(start time.Time) bool {
+	if p.think != nil && !pause(p.think.delay(), p.quit) {
+		return false
+	}
+	return pause(time.Until(start.Add(p.pacing)), p.quit)
+}
+
+// makeResult creates result of plan after it ends with decision
+func (p *plan) makeResult(decision next) *config.ScheduleResult {
+	res := &config.ScheduleResult{
+		Name:     p.name,
+		Success:  decision == nextFinished,
+		Start:    p.start,
+		End:      p.end,
+		PerfStat: p.stat,
+		Tests:    p.testResults,
+		Backends: p.lbResults,
+		Mix:      p.mixResults,
+		Local:    snapshot(p.bg.local),
+		Global:   snapshot(p.bg.global),
+	}
+	if p.stat != nil {
+		res.Passed = p.stat.Requests - p.stat.Errors
+		res.Failed = p.stat.Errors
+	}
+	if !res.Success {
+		if p.err != nil {
+			res.Error = p.err.Error()
+		} else {
+			res.Error = "schedule aborts"
+		}
+	}
+	return res
 }
 
 func (p *plan) close() {
 	if p.bg != nil {
+		p.bg.closeClients()
 		p.bg.globalClose()
 	}
 	p.target.close()
 }
+
+// level calculates expected concurrency and QPS after stages run for elapsed.
+// ok will be false if all stages end.
+func (p *plan) level(elapsed time.Duration) (concurrency int, qps float64, ok bool) {
+	concurrency, qps = p.concurrent, p.qps
+	for _, s := range p.stages {
+		to, toQPS := s.concurrency, s.qps
+		if to == 0 {
+			to = concurrency
+		}
+		if toQPS == 0 {
+			toQPS = qps
+		}
+		if elapsed < s.du {
+			ratio := float64(elapsed) / float64(s.du)
+			concurrency += int(float64(to-concurrency) * ratio)
+			if qps == 0 {
+				// no limit before, take target directly
+				qps = toQPS
+			} else {
+				qps += (toQPS - qps) * ratio
+			}
+			if concurrency < 1 {
+				concurrency = 1
+			}
+			return concurrency, qps, true
+		}
+		elapsed -= s.du
+		concurrency, qps = to, toQPS
+	}
+	return concurrency, qps, false
+}
+
 func (p *plan) runOneByOne() next {
+	var deadline time.Time
+	if p.duration > 0 {
+		deadline = time.Now().Add(p.duration)
+	}
 	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nextFinished
+		}
 		p.bg.next()
 		p.bg.setLocalEnv(KeyRoutine, "-1")
 		seq := atomic.AddInt64(&p.seq, 1)
 		p.bg.setLocalEnv(KeySequence, strconv.Itoa(int(seq)))
 
+		start := time.Now()
 		decision := p.target.run(p.bg)
 		if decision != nextContinue {
 			if decision != nextFinished {
+				p.setError(p.bg.getError())
 				if p.bg.inDebug() {
 					fmt.Printf("plan %s failed, error: %+v\n", p.name, p.bg.getError())
 				} else {
@@ -51,75 +207,344 @@ func (p *plan) runOneByOne() next {
 			}
 			return decision
 		}
+		if !p.rest(start) {
+			return nextFinished
+		}
 	}
 }
 
+// runConcurrent runs target in n routines. If stages are defined, routines will
+// be created or retired following stages.
+//
+// A routine reports nextContinue if it is stopped or retired. A routine that
+// exits by itself, like its feeder runs out, is not replaced by stages.
 func (p *plan) runConcurrent(n int) next {
-	if n <= 1 {
+	if n <= 1 && len(p.stages) == 0 {
 		glog.Errorf("concurrent number is %d, we require it at least 2", n)
 		return nextAbortAll
 	}
-	stop := false
-	c := make(chan next)
-	for i := 0; i < n; i++ {
-		go func(idx int) {
+	if n < 1 {
+		n = 1
+	}
+
+	// routine is a routine neither retired nor exited
+	type routine struct {
+		idx    int
+		retire *int32
+	}
+	// exit is reported by a routine when it exits
+	type exit struct {
+		idx      int
+		decision next
+	}
+
+	var stop int32
+	c := make(chan exit)
+	var live []routine
+	running := 0
+	routines := 0  // routine id never reused after retired
+	ended := false // a routine exits by itself, no more routines are created
+
+	spawn := func() {
+		idx := routines
+		routines++
+		retire := new(int32)
+		live = append(live, routine{idx: idx, retire: retire})
+		running++
+		go func() {
 			sn := strconv.Itoa(idx)
 			bg := p.bg.dup()
-			for !stop {
+			defer bg.closeClients()
+			for atomic.LoadInt32(&stop) == 0 && atomic.LoadInt32(retire) == 0 {
 				bg.next()
 				bg.setLocalEnv(KeyRoutine, sn)
 				seq := atomic.AddInt64(&p.seq, 1)
 				bg.setLocalEnv(KeySequence, strconv.Itoa(int(seq)))
+				start := time.Now()
 				if decision := p.target.run(bg); decision != nextContinue {
 					// maybe error, may finished
 					if decision != nextFinished {
 						glog.Errorf("routine %d exit with err %v", idx, bg.getError())
+						p.setError(bg.getError())
 					}
-					c <- decision
+					c <- exit{idx: idx, decision: decision}
 					return
 				}
+				if !p.rest(start) {
+					break
+				}
 			}
 
-			c <- nextAbortPlan
-		}(i)
+			c <- exit{idx: idx, decision: nextContinue}
+		}()
+	}
+	retire := func() {
+		last := len(live) - 1
+		atomic.StoreInt32(live[last].retire, 1)
+		live = live[:last]
+	}
+
+	for i := 0; i < n; i++ {
+		spawn()
+	}
+
+	var deadline <-chan time.Time
+	if p.duration > 0 {
+		timer := time.NewTimer(p.duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	var tick <-chan time.Time
+	start := time.Now()
+	if len(p.stages) > 0 {
+		ticker := time.NewTicker(stageInterval)
+		defer ticker.Stop()
+		tick = ticker.C
 	}
 
-	waiting := n
+	result := nextFinished
+	for running > 0 {
+		select {
+		case e := <-c:
+			running--
+			for i, r := range live {
+				if r.idx == e.idx {
+					live = append(live[:i], live[i+1:]...)
+					ended = true
+					break
+				}
+			}
+			if d := e.decision; d != nextFinished && d != nextContinue {
+				atomic.StoreInt32(&stop, 1)
+				p.halt()
+				if result == nextFinished {
+					result = d
+				}
+			}
+		case <-deadline:
+			atomic.StoreInt32(&stop, 1)
+			p.halt()
+		case <-tick:
+			if atomic.LoadInt32(&stop) != 0 {
+				break
+			}
+			concurrency, qps, ok := p.level(time.Since(start))
+			if !ok {
+				atomic.StoreInt32(&stop, 1)
+				p.halt()
+				break
+			}
+			if p.fc != nil {
+				p.fc.setQPS(qps)
+			}
+			for !ended && len(live) < concurrency {
+				spawn()
+			}
+			for len(live) > concurrency {
+				retire()
+			}
+		}
+	}
+
+	return result
+}
+
+// runArrival starts target following arrival model regardless of how many targets
+// are running, until count or duration is reached, or any target fails.
+//
+// Each running target takes a slot, ROUTINE is set to slot index.
+func (p *plan) runArrival() next {
+	a := p.arrival
+	var stop int32
+	var started, dropped, late int64
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
 	result := nextFinished
 
-	for d := range c {
-		if d != nextFinished {
-			stop = true
-			if result == nextFinished {
-				result = d
+	slots := make(chan int, a.maxInFlight)
+	for i := 0; i < a.maxInFlight; i++ {
+		slots <- i
+	}
+	// routine clients are kept by slot so that targets taking the same slot
+	// share connections
+	clients := make([]map[*http.Client]*http.Client, a.maxInFlight)
+	defer func() {
+		for _, m := range clients {
+			for _, c := range m {
+				c.CloseIdleConnections()
 			}
 		}
+	}()
 
-		waiting--
-		if waiting == 0 {
+	var deadline time.Time
+	at := time.Now()
+	if p.duration > 0 {
+		deadline = at.Add(p.duration)
+	}
+
+	for atomic.LoadInt32(&stop) == 0 {
+		at = at.Add(a.interval())
+		if !deadline.IsZero() && at.After(deadline) {
+			time.Sleep(time.Until(deadline))
+			break
+		}
+		time.Sleep(time.Until(at))
+		if atomic.LoadInt32(&stop) != 0 {
 			break
 		}
+
+		var slot int
+		got := true
+		select {
+		case slot = <-slots:
+		default:
+			if a.drop {
+				dropped++
+				continue
+			}
+			// wait for a slot until deadline or stop
+			late++
+			var timer *time.Timer
+			var timeout <-chan time.Time
+			if !deadline.IsZero() {
+				timer = time.NewTimer(time.Until(deadline))
+				timeout = timer.C
+			}
+			select {
+			case slot = <-slots:
+			case <-timeout:
+				got = false
+			case <-p.quit:
+				got = false
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			// later arrivals are scheduled from now instead of catching up
+			at = time.Now()
+		}
+		if !got {
+			break
+		}
+		if atomic.LoadInt32(&stop) != 0 || (!deadline.IsZero() && time.Now().After(deadline)) {
+			slots <- slot
+			break
+		}
+
+		started++
+		wg.Add(1)
+		go func(slot int) {
+			defer func() {
+				slots <- slot
+				wg.Done()
+			}()
+			bg := p.bg.dup()
+			bg.clients = clients[slot]
+			defer func() {
+				clients[slot] = bg.clients
+			}()
+			bg.next()
+			bg.setLocalEnv(KeyRoutine, strconv.Itoa(slot))
+			seq := atomic.AddInt64(&p.seq, 1)
+			bg.setLocalEnv(KeySequence, strconv.Itoa(int(seq)))
+			if decision := p.target.run(bg); decision != nextContinue {
+				atomic.StoreInt32(&stop, 1)
+				p.halt()
+				if decision != nextFinished {
+					glog.Errorf("arrival %d exit with err %v", seq, bg.getError())
+					p.setError(bg.getError())
+					mtx.Lock()
+					if result == nextFinished {
+						result = decision
+					}
+					mtx.Unlock()
+				}
+			}
+		}(slot)
 	}
 
+	wg.Wait()
+	p.bg.setLocalEnv("_.arrival.started", strconv.FormatInt(started, 10))
+	p.bg.setLocalEnv("_.arrival.dropped", strconv.FormatInt(dropped, 10))
+	p.bg.setLocalEnv("_.arrival.late", strconv.FormatInt(late, 10))
 	return result
 }
-func (p *plan) run() next {
+
+// writePerfReport writes latency statistics and histogram to perfReport in json.
+func (p *plan) writePerfReport() error {
+	var tests map[string]*config.PerfStat
+	if len(p.testResults) > 0 {
+		tests = make(map[string]*config.PerfStat)
+		for _, t := range p.testResults {
+			tests[t.Name] = t.PerfStat
+		}
+	}
+	b, err := json.MarshalIndent(&struct {
+		Schedule string
+		*config.PerfStat
+		Tests map[string]*config.PerfStat `json:",omitempty"`
+	}{p.name, p.stat, tests}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p.perfReport), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.perfReport, b, 0666)
+}
+func (p *plan) run() (decision next) {
+	p.start = time.Now()
+	defer func() {
+		p.end = time.Now()
+		p.result = p.makeResult(decision)
+	}()
+
 	if p.preprocess != nil {
 		_, err := p.preprocess.compose(p.bg)
 		if err != nil {
 			p.bg.setError(errors.Wrapf(err, "plan %s preprocess", p.name))
 		}
 		if p.bg.hasError() {
+			p.setError(p.bg.getError())
 			return nextAbortPlan
 		}
 	}
 	defer func() {
-		p.bg.commit()
+		p.stat = p.bg.commit()
+		for _, name := range p.tests {
+			t := p.testPerf[name].result(name)
+			p.testResults = append(p.testResults, t)
+			p.bg.setCountEnv("_.test."+name+".", t.PerfStat)
+		}
+		for _, lb := range p.balancers {
+			p.lbResults = append(p.lbResults, lb.results()...)
+		}
+		if p.mix != nil {
+			p.mixResults = p.mix.results()
+			for i, m := range p.mixResults {
+				p.bg.setLocalEnv("_.mix."+strconv.Itoa(i)+".picked", strconv.FormatInt(m.Picked, 10))
+			}
+		}
+		if p.stat != nil && len(p.perfReport) > 0 {
+			if err := p.writePerfReport(); err != nil {
+				glog.Errorf("plan %s write perf report: %v", p.name, err)
+			}
+		}
 		if p.postprocess != nil {
 			_, _ = p.postprocess.compose(p.bg)
 		}
 	}()
-	if p.concurrent > 1 {
+	defer p.halt()
+	if p.duration > 0 {
+		// pauses end once duration elapses
+		t := time.AfterFunc(p.duration, p.halt)
+		defer t.Stop()
+	}
+	if p.arrival != nil {
+		return p.runArrival()
+	}
+	if p.concurrent > 1 || len(p.stages) > 0 {
 		return p.runConcurrent(p.concurrent)
 	}
 	return p.runOneByOne()
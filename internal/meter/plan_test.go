@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -381,3 +382,251 @@ func TestPlanInvalidPreProcess(t *testing.T) {
 	}
 	p.close()
 }
+
+type testPlanStageRunner struct {
+	running int32
+	max     int32
+}
+
+func (t *testPlanStageRunner) close() {
+}
+func (t *testPlanStageRunner) run(bg *background) next {
+	n := atomic.AddInt32(&t.running, 1)
+	for {
+		m := atomic.LoadInt32(&t.max)
+		if n <= m || atomic.CompareAndSwapInt32(&t.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	atomic.AddInt32(&t.running, -1)
+	return nextContinue
+}
+func TestPlanDuration(t *testing.T) {
+	p := &plan{
+		name:     "test-plan-duration",
+		duration: 300 * time.Millisecond,
+	}
+
+	var err error
+	p.bg, err = makeBackground(nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p.target = &testPlanStageRunner{}
+
+	start := time.Now()
+	if ret := p.run(); ret != nextFinished {
+		t.Fatalf("expect finish, get %v", ret)
+	}
+	if du := time.Since(start); du < 300*time.Millisecond || du > time.Second {
+		t.Fatalf("unexpected duration %v", du)
+	}
+
+	p.concurrent = 10
+	start = time.Now()
+	if ret := p.run(); ret != nextFinished {
+		t.Fatalf("expect finish, get %v", ret)
+	}
+	if du := time.Since(start); du < 300*time.Millisecond || du > time.Second {
+		t.Fatalf("unexpected duration %v", du)
+	}
+	p.close()
+}
+func TestPlanStages(t *testing.T) {
+	p := &plan{
+		name:       "test-plan-stages",
+		concurrent: 1,
+		stages: []stage{
+			{du: 300 * time.Millisecond, concurrency: 20},
+			{du: 300 * time.Millisecond},
+			{du: 300 * time.Millisecond, concurrency: 1},
+		},
+	}
+
+	var err error
+	p.bg, err = makeBackground(nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tpr := &testPlanStageRunner{}
+	p.target = tpr
+
+	done := make(chan next, 1)
+	go func() {
+		done <- p.run()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&tpr.max); n > 2 {
+		t.Fatalf("expect ramp up slowly, get %d routines", n)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if n := atomic.LoadInt32(&tpr.max); n < 15 {
+		t.Fatalf("expect at least 15 routines, get %d", n)
+	}
+	select {
+	case ret := <-done:
+		if ret != nextFinished {
+			t.Fatalf("expect finish, get %v", ret)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expect finish after all stages")
+	}
+	p.close()
+}
+
+// testPlanDryRunner finishes after left runs like a feeder runs dry, and the
+// last run is slow so that routines are still running after feeder is dry.
+type testPlanDryRunner struct {
+	left     int32
+	routines int32
+	dry      int32 // routines created when feeder runs dry
+}
+
+func (t *testPlanDryRunner) close() {
+}
+func (t *testPlanDryRunner) run(bg *background) next {
+	idx, _ := strconv.Atoi(bg.getLocalEnv(KeyRoutine))
+	for {
+		n := atomic.LoadInt32(&t.routines)
+		if int32(idx) < n || atomic.CompareAndSwapInt32(&t.routines, n, int32(idx)+1) {
+			break
+		}
+	}
+	switch left := atomic.AddInt32(&t.left, -1); {
+	case left < 0:
+		return nextFinished
+	case left == 0:
+		atomic.StoreInt32(&t.dry, atomic.LoadInt32(&t.routines))
+		time.Sleep(300 * time.Millisecond)
+	default:
+		time.Sleep(5 * time.Millisecond)
+	}
+	return nextContinue
+}
+func TestPlanStagesDry(t *testing.T) {
+	p := &plan{
+		name:       "test-plan-stages-dry",
+		concurrent: 1,
+		stages: []stage{
+			{du: 400 * time.Millisecond, concurrency: 20},
+			{du: 400 * time.Millisecond},
+		},
+	}
+
+	var err error
+	p.bg, err = makeBackground(nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tpr := &testPlanDryRunner{left: 30}
+	p.target = tpr
+
+	start := time.Now()
+	if ret := p.run(); ret != nextFinished {
+		t.Fatalf("expect finish, get %v", ret)
+	}
+	if du := time.Since(start); du > 700*time.Millisecond {
+		t.Fatalf("expect finish once feeder runs dry, get %v", du)
+	}
+	// a routine created right before feeder runs dry may not have run yet
+	if dry, n := atomic.LoadInt32(&tpr.dry), atomic.LoadInt32(&tpr.routines); n > dry+1 {
+		t.Fatalf("expect no routine created after feeder runs dry at %d routines, get %d", dry, n)
+	}
+	p.close()
+}
+
+func TestPlanStageLevel(t *testing.T) {
+	p := &plan{
+		concurrent: 10,
+		stages: []stage{
+			{du: 10 * time.Second, concurrency: 110, qps: 1000},
+			{du: 10 * time.Second},
+			{du: 10 * time.Second, concurrency: 10, qps: 100},
+		},
+	}
+	cases := []struct {
+		elapsed     time.Duration
+		concurrency int
+		qps         float64
+		ok          bool
+	}{
+		{0, 10, 1000, true},
+		{5 * time.Second, 60, 1000, true},
+		{15 * time.Second, 110, 1000, true},
+		{25 * time.Second, 60, 550, true},
+		{30 * time.Second, 10, 100, false},
+	}
+	for _, c := range cases {
+		concurrency, qps, ok := p.level(c.elapsed)
+		if concurrency != c.concurrency || qps != c.qps || ok != c.ok {
+			t.Fatalf("elapsed %v expect %d %v %v, get %d %v %v",
+				c.elapsed, c.concurrency, c.qps, c.ok, concurrency, qps, ok)
+		}
+	}
+}
+func TestPlanArrival(t *testing.T) {
+	p := &plan{
+		name:     "test-plan-arrival",
+		duration: 500 * time.Millisecond,
+		arrival: &arrival{
+			rate:        200,
+			maxInFlight: 100,
+		},
+	}
+
+	var err error
+	p.bg, err = makeBackground(nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p.target = &testPlanStageRunner{}
+
+	if ret := p.run(); ret != nextFinished {
+		t.Fatalf("expect finish, get %v", ret)
+	}
+	started, _ := strconv.Atoi(p.bg.getLocalEnv("_.arrival.started"))
+	if started < 80 || started > 101 {
+		t.Fatalf("expect about 100 arrivals, get %d", started)
+	}
+	if s := p.bg.getLocalEnv("_.arrival.late"); s != "0" {
+		t.Fatalf("expect no late arrival, get %s", s)
+	}
+
+	// slow target with only 1 slot
+	p.target = &testPlanArrivalRunner{}
+	p.arrival = &arrival{
+		rate:        200,
+		poisson:     true,
+		maxInFlight: 1,
+		drop:        true,
+	}
+	if ret := p.run(); ret != nextFinished {
+		t.Fatalf("expect finish, get %v", ret)
+	}
+	started, _ = strconv.Atoi(p.bg.getLocalEnv("_.arrival.started"))
+	dropped, _ := strconv.Atoi(p.bg.getLocalEnv("_.arrival.dropped"))
+	if started > 15 || dropped < 50 {
+		t.Fatalf("expect most arrivals dropped, started %d dropped %d", started, dropped)
+	}
+
+	p.arrival.drop = false
+	if ret := p.run(); ret != nextFinished {
+		t.Fatalf("expect finish, get %v", ret)
+	}
+	late, _ := strconv.Atoi(p.bg.getLocalEnv("_.arrival.late"))
+	if late == 0 || p.bg.getLocalEnv("_.arrival.dropped") != "0" {
+		t.Fatalf("expect late arrivals without drop, late %d", late)
+	}
+	p.close()
+}
+
+type testPlanArrivalRunner struct{}
+
+func (t *testPlanArrivalRunner) close() {
+}
+func (t *testPlanArrivalRunner) run(bg *background) next {
+	time.Sleep(50 * time.Millisecond)
+	return nextContinue
+}
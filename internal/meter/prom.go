@@ -0,0 +1,284 @@
+package meter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forrestjgq/glog"
+)
+
+// latency histogram buckets in seconds
+var promBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// promSeries holds metrics of a test in a schedule, or a route of an HTTP server.
+type promSeries struct {
+	labels   string // formatted labels without braces
+	requests int64
+	inFlight int64
+	status   map[int]int64
+	errors   map[string]int64 // failure class -> count
+	buckets  []int64          // count of latency in each bucket, not cumulative
+	count    int64
+	sum      float64
+	mtx      sync.Mutex
+}
+
+func (s *promSeries) enter() {
+	s.mtx.Lock()
+	s.inFlight++
+	s.mtx.Unlock()
+}
+func (s *promSeries) leave() {
+	s.mtx.Lock()
+	s.inFlight--
+	s.mtx.Unlock()
+}
+
+// observe records latency in microseconds
+func (s *promSeries) observe(latency int64) {
+	v := float64(latency) / 1e6
+	i := sort.SearchFloat64s(promBuckets, v)
+	s.mtx.Lock()
+	s.buckets[i]++
+	s.count++
+	s.sum += v
+	s.mtx.Unlock()
+}
+
+// record counts a finished request, status is 0 if server does not respond, and
+// class is the failure class if request fails, or empty.
+func (s *promSeries) record(status int, class string) {
+	s.mtx.Lock()
+	s.requests++
+	if status > 0 {
+		s.status[status]++
+	}
+	if len(class) > 0 {
+		s.errors[class]++
+	}
+	s.mtx.Unlock()
+}
+
+// promRegistry holds all series of client tests and server routes, they are kept
+// in the whole process life so counters never go back.
+type promRegistry struct {
+	mtx    sync.Mutex
+	client map[string]*promSeries
+	server map[string]*promSeries
+}
+
+var prom = &promRegistry{
+	client: make(map[string]*promSeries),
+	server: make(map[string]*promSeries),
+}
+
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// promLabels formats label pairs k1, v1, k2, v2...
+func promLabels(kv ...string) string {
+	var s []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		s = append(s, kv[i]+`="`+promEscape(kv[i+1])+`"`)
+	}
+	return strings.Join(s, ",")
+}
+
+func (r *promRegistry) get(m map[string]*promSeries, labels string) *promSeries {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	s, ok := m[labels]
+	if !ok {
+		s = &promSeries{
+			labels:  labels,
+			status:  make(map[int]int64),
+			errors:  make(map[string]int64),
+			buckets: make([]int64, len(promBuckets)+1),
+		}
+		m[labels] = s
+	}
+	return s
+}
+
+// clientSeries returns series of test in schedule of config
+func (r *promRegistry) clientSeries(cfg, schedule, test string) *promSeries {
+	return r.get(r.client, promLabels("config", cfg, "schedule", schedule, "test", test))
+}
+
+// serverSeries returns series of route of HTTP server
+func (r *promRegistry) serverSeries(server, route string) *promSeries {
+	return r.get(r.server, promLabels("server", server, "route", route))
+}
+
+func promJoin(labels, extra string) string {
+	if len(labels) == 0 {
+		return extra
+	}
+	if len(extra) == 0 {
+		return labels
+	}
+	return labels + "," + extra
+}
+
+// writeFamily writes metrics of all series with prefix in text exposition format
+func writeFamily(w io.Writer, prefix string, m map[string]*promSeries) {
+	var all []*promSeries
+	for _, s := range m {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].labels < all[j].labels
+	})
+
+	type family struct {
+		name, typ, help string
+		write           func(s *promSeries)
+	}
+	line := func(name, labels string, v interface{}) {
+		_, _ = fmt.Fprintf(w, "%s{%s} %v\n", name, labels, v)
+	}
+	families := []family{
+		{prefix + "requests_total", "counter", "Requests finished.", func(s *promSeries) {
+			line(prefix+"requests_total", s.labels, s.requests)
+		}},
+		{prefix + "errors_total", "counter", "Requests failed by failure class.", func(s *promSeries) {
+			var classes []string
+			for k := range s.errors {
+				classes = append(classes, k)
+			}
+			sort.Strings(classes)
+			for _, c := range classes {
+				line(prefix+"errors_total", promJoin(s.labels, promLabels("class", c)), s.errors[c])
+			}
+		}},
+		{prefix + "responses_total", "counter", "Responses by HTTP status code.", func(s *promSeries) {
+			var codes []int
+			for k := range s.status {
+				codes = append(codes, k)
+			}
+			sort.Ints(codes)
+			for _, c := range codes {
+				line(prefix+"responses_total", promJoin(s.labels, promLabels("status", strconv.Itoa(c))), s.status[c])
+			}
+		}},
+		{prefix + "in_flight", "gauge", "Requests in flight.", func(s *promSeries) {
+			line(prefix+"in_flight", s.labels, s.inFlight)
+		}},
+		{prefix + "latency_seconds", "histogram", "Latency of requests responded.", func(s *promSeries) {
+			var n int64
+			for i, b := range promBuckets {
+				n += s.buckets[i]
+				le := strconv.FormatFloat(b, 'g', -1, 64)
+				line(prefix+"latency_seconds_bucket", promJoin(s.labels, promLabels("le", le)), n)
+			}
+			line(prefix+"latency_seconds_bucket", promJoin(s.labels, promLabels("le", "+Inf")), s.count)
+			line(prefix+"latency_seconds_sum", s.labels, strconv.FormatFloat(s.sum, 'g', -1, 64))
+			line(prefix+"latency_seconds_count", s.labels, s.count)
+		}},
+	}
+
+	for _, f := range families {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, s := range all {
+			s.mtx.Lock()
+			f.write(s)
+			s.mtx.Unlock()
+		}
+	}
+}
+
+// ServeHTTP writes all metrics in Prometheus text format
+func (r *promRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.mtx.Lock()
+	client := make(map[string]*promSeries, len(r.client))
+	for k, v := range r.client {
+		client[k] = v
+	}
+	server := make(map[string]*promSeries, len(r.server))
+	for k, v := range r.server {
+		server[k] = v
+	}
+	r.mtx.Unlock()
+
+	writeFamily(w, "gmeter_", client)
+	writeFamily(w, "gmeter_server_", server)
+}
+
+// promStatusWriter records status code written by handler
+type promStatusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *promStatusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+func (w *promStatusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack lets WebSocket route take over the connection
+func (w *promStatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijack")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// promHandler wraps a route handler of HTTP server to record metrics
+func promHandler(server, route string, h http.Handler) http.Handler {
+	s := prom.serverSeries(server, route)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.enter()
+		start := time.Now()
+		pw := &promStatusWriter{ResponseWriter: w}
+		h.ServeHTTP(pw, r)
+		s.observe(time.Since(start).Microseconds())
+		s.leave()
+		if pw.status == 0 {
+			pw.status = http.StatusOK
+		}
+		s.record(pw.status, "")
+	})
+}
+
+var promServer *http.Server
+
+// startMetrics serves Prometheus metrics on addr at path /metrics
+func startMetrics(addr string) error {
+	if promServer != nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prom)
+	promServer = &http.Server{Handler: mux}
+	go func() {
+		_ = promServer.Serve(l)
+	}()
+	glog.Infof("serve prometheus metrics at http://%s/metrics", l.Addr().String())
+	return nil
+}
@@ -0,0 +1,94 @@
+package meter
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func scrape(t *testing.T, url string) string {
+	rsp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer func() {
+		_ = rsp.Body.Close()
+	}()
+	b, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return string(b)
+}
+
+func TestPromClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := loadFixture(t, "junit.json", srv.URL)
+	cfg.Name = "prom-client"
+	_, _ = runConfig(cfg)
+
+	m := httptest.NewServer(prom)
+	defer m.Close()
+	s := scrape(t, m.URL)
+
+	for _, expect := range []string{
+		`# TYPE gmeter_requests_total counter`,
+		`gmeter_requests_total{config="prom-client",schedule="pass",test="ping"} 2`,
+		`gmeter_requests_total{config="prom-client",schedule="fail",test="missing"} 1`,
+		`gmeter_errors_total{config="prom-client",schedule="fail",test="missing",class="check"} 1`,
+		`gmeter_responses_total{config="prom-client",schedule="pass",test="ping",status="200"} 2`,
+		`gmeter_responses_total{config="prom-client",schedule="fail",test="missing",status="404"} 1`,
+		`gmeter_in_flight{config="prom-client",schedule="pass",test="ping"} 0`,
+		`gmeter_latency_seconds_bucket{config="prom-client",schedule="pass",test="ping",le="+Inf"} 2`,
+		`gmeter_latency_seconds_count{config="prom-client",schedule="pass",test="ping"} 2`,
+	} {
+		if !strings.Contains(s, expect+"\n") {
+			t.Fatalf("expect %s in metrics:\n%s", expect, s)
+		}
+	}
+}
+
+func TestPromServer(t *testing.T) {
+	c := &config.HttpServers{
+		Servers: map[string]*config.HttpServer{
+			"prom-server": {
+				Address: "127.0.0.1:0",
+				Metrics: "/metrics",
+				Routes: []*config.Route{
+					{Method: "GET", Path: "/ping", Request: &config.RequestProcess{}},
+				},
+			},
+		},
+	}
+	err := StartHTTPServerConfig(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer StopAll()
+
+	url := "http://127.0.0.1:" + strconv.Itoa(servers["prom-server"].port)
+	for i := 0; i < 3; i++ {
+		_ = scrape(t, url+"/ping")
+	}
+	s := scrape(t, url+"/metrics")
+	for _, expect := range []string{
+		`gmeter_server_requests_total{server="prom-server",route="GET /ping"} 3`,
+		`gmeter_server_responses_total{server="prom-server",route="GET /ping",status="200"} 3`,
+		`gmeter_server_latency_seconds_count{server="prom-server",route="GET /ping"} 3`,
+	} {
+		if !strings.Contains(s, expect+"\n") {
+			t.Fatalf("expect %s in metrics:\n%s", expect, s)
+		}
+	}
+}
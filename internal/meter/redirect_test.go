@@ -0,0 +1,61 @@
+package meter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			atomic.AddInt32(&hits, 1)
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := loadFixture(t, "redirect.json", srv.URL)
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	expect := map[string]string{
+		"first":    srv.URL + "/a",
+		"second":   srv.URL + "/b",
+		"final":    srv.URL + "/c",
+		"chain":    "302,301,200",
+		"location": "/b",
+	}
+	for k, v := range expect {
+		if cr.DB[k] != v {
+			t.Errorf("%s expect %s get %s", k, v, cr.DB[k])
+		}
+	}
+
+	// host policy applies if test does not define one, and redirect limit
+	// is not retried
+	atomic.StoreInt32(&hits, 0)
+	cfg.Hosts["-"].Redirect = "1"
+	if _, err = runConfig(cfg); err == nil {
+		t.Errorf("expect fail for too many redirects")
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("expect redirect limit not retried, get %d requests", n)
+	}
+	cfg.Tests["follow"].Redirect = "2"
+	if _, err = runConfig(cfg); err != nil {
+		t.Errorf("run config: %v", err)
+	}
+
+	cfg.Tests["follow"].Redirect = "bad"
+	if _, err = runConfig(cfg); err == nil {
+		t.Errorf("expect invalid redirect policy")
+	}
+}
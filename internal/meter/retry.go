@@ -0,0 +1,101 @@
+package meter
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+	"github.com/pkg/errors"
+)
+
+// retryPolicy decides if a failed request should be retried and how long to wait.
+type retryPolicy struct {
+	attempts   int
+	status     map[int]struct{}
+	classes    map[string]struct{}
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+	count      bool // count retried attempts in statistics
+}
+
+// defaultRetry retries once immediately if HTTP execution fails.
+var defaultRetry = &retryPolicy{
+	attempts: 2,
+	classes: map[string]struct{}{
+		failConnRefused: {},
+		failTimeout:     {},
+		failDNS:         {},
+		failTLS:         {},
+		failRequest:     {},
+	},
+}
+
+func makeRetryPolicy(r *config.Retry) (*retryPolicy, error) {
+	if err := r.Check(); err != nil {
+		return nil, err
+	}
+	p := &retryPolicy{
+		attempts: r.Attempts,
+		status:   make(map[int]struct{}),
+		classes:  make(map[string]struct{}),
+		jitter:   r.Jitter,
+		count:    r.CountRetries,
+	}
+	for _, s := range r.Status {
+		p.status[s] = struct{}{}
+	}
+	for _, c := range r.Classes {
+		p.classes[c] = struct{}{}
+	}
+	var err error
+	if len(r.Backoff) > 0 {
+		if p.backoff, err = time.ParseDuration(r.Backoff); err != nil {
+			return nil, errors.Wrapf(err, "parse backoff %s", r.Backoff)
+		}
+	}
+	if len(r.MaxBackoff) > 0 {
+		if p.maxBackoff, err = time.ParseDuration(r.MaxBackoff); err != nil {
+			return nil, errors.Wrapf(err, "parse max backoff %s", r.MaxBackoff)
+		}
+	}
+	return p, nil
+}
+
+// retriable tells if a request failed with class, or responded with status, should
+// be retried. status is 0 if server does not respond.
+func (p *retryPolicy) retriable(status int, class string) bool {
+	if len(class) > 0 {
+		_, ok := p.classes[class]
+		return ok
+	}
+	_, ok := p.status[status]
+	return ok
+}
+
+// delay returns backoff before retrying after attempt-th attempt fails.
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d > 0 && d < math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if p.maxBackoff > 0 && d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if p.jitter > 0 && d > 0 {
+		d = time.Duration(float64(d) * (1 + p.jitter*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+// wait backs off after attempt-th attempt fails, it returns false without waiting
+// out if quit is closed, and request should not be retried.
+func (p *retryPolicy) wait(attempt int, quit <-chan struct{}) bool {
+	select {
+	case <-quit:
+		return false
+	default:
+	}
+	return pause(p.delay(attempt), quit)
+}
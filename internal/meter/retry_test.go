@@ -0,0 +1,113 @@
+package meter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p, err := makeRetryPolicy(&config.Retry{Attempts: 5, Backoff: "10ms", MaxBackoff: "30ms"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expect := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	for i, d := range expect {
+		if v := p.delay(i + 1); v != d {
+			t.Errorf("attempt %d expect delay %v get %v", i+1, d, v)
+		}
+	}
+
+	p.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if v := p.delay(1); v < 5*time.Millisecond || v > 15*time.Millisecond {
+			t.Fatalf("delay %v out of jitter range", v)
+		}
+	}
+
+	for _, r := range []*config.Retry{
+		{Attempts: 0},
+		{Attempts: 2, Jitter: 2},
+		{Attempts: 2, Classes: []string{"check"}},
+		{Attempts: 2, Backoff: "1x"},
+	} {
+		if _, err = makeRetryPolicy(r); err == nil {
+			t.Errorf("expect invalid retry %+v", r)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail 2 of every 3 calls
+		if atomic.AddInt32(&calls, 1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := loadFixture(t, "retry.json", srv.URL)
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	if calls != 6 {
+		t.Errorf("expect 6 calls, get %d", calls)
+	}
+	if cr.DB["attempt"] != "3" {
+		t.Errorf("expect attempt 3, get %s", cr.DB["attempt"])
+	}
+	tests := cr.Schedules[0].Tests
+	if len(tests) != 1 || tests[0].Requests != 2 || tests[0].Errors != 0 {
+		t.Fatalf("unexpected result %+v", tests[0])
+	}
+
+	// retried attempts are counted
+	atomic.StoreInt32(&calls, 0)
+	cfg.Tests["flaky"].Retry.CountRetries = true
+	cr, err = runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	st := cr.Schedules[0].Tests[0]
+	if st.Requests != 6 || st.Count != 6 || st.Status[http.StatusServiceUnavailable] != 4 {
+		t.Fatalf("unexpected result %+v", st.PerfStat)
+	}
+
+	// not enough attempts
+	atomic.StoreInt32(&calls, 0)
+	cfg.Tests["flaky"].Retry.Attempts = 2
+	if _, err = runConfig(cfg); err == nil {
+		t.Fatalf("expect fail")
+	}
+}
+
+func TestRetryStop(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// schedule stops while backing off, and the first attempt is the last
+	cfg := loadFixture(t, "retry.json", srv.URL)
+	cfg.Tests["flaky"].Retry.Backoff = "2s"
+	cfg.Schedules[0].Duration = "300ms"
+	start := time.Now()
+	if _, err := runConfig(cfg); err == nil {
+		t.Fatalf("expect fail")
+	}
+	if du := time.Since(start); du > time.Second {
+		t.Fatalf("expect backoff ends once schedule stops, get %v", du)
+	}
+	if calls != 1 {
+		t.Fatalf("expect 1 call, get %d", calls)
+	}
+}
@@ -2,6 +2,7 @@ package meter
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/forrestjgq/glog"
 
+	"github.com/forrestjgq/gmeter/config"
+
 	"github.com/forrestjgq/gomark"
 )
 
@@ -20,26 +23,100 @@ type runner struct {
 	provSrc providerSource
 	c       consumer
 	name    string
+	fc      *flowControl  // test level flow control, optional
+	perf    *perf         // test level perf, optional
+	retry   *retryPolicy  // retry policy, optional
+	lb      *balancer     // backend balancer of host, optional
+	stream  *streamReader // streaming response reader, optional
+
+	compress   string // request body encoding, optional
+	decompress bool   // decompress response by its encoding
+
+	auth *authenticator // authentication, optional
+}
+
+// record counts a finished request into schedule and test perf, err is the
+// failure before response is processed if any.
+func (r *runner) record(bg *background, status int, err error) {
+	var f *config.Failure
+	class := bg.failClass
+	if err != nil {
+		f = &config.Failure{Message: err.Error()}
+	} else if len(class) > 0 {
+		f = &config.Failure{
+			Message:  bg.getLocalEnv(KeyFailure),
+			Response: bg.getLocalEnv(KeyResponse),
+		}
+	}
+	if f != nil {
+		f.Class = class
+		f.URL = bg.getLocalEnv(KeyURL)
+		f.Request = bg.getLocalEnv(KeyRequest)
+		if status > 0 {
+			f.Status = strconv.Itoa(status)
+		}
+	}
+	if bg.perf != nil {
+		bg.perf.record(status, f)
+	}
+	if r.perf != nil {
+		r.perf.record(status, f)
+	}
+	if bg.backend != nil {
+		bg.backend.perf.record(status, f)
+	}
+}
+
+// conn counts connection a request is sent on
+func (r *runner) conn(bg *background, trace *reqTrace) {
+	got, reused := trace.conn()
+	if !got {
+		return
+	}
+	if bg.perf != nil {
+		bg.perf.conn(reused)
+	}
+	if r.perf != nil {
+		r.perf.conn(reused)
+	}
+	if bg.backend != nil {
+		bg.backend.perf.conn(reused)
+	}
 }
 
 func (r *runner) close() {
 	r.provSrc.close()
 }
 
-func (r *runner) do(bg *background, method, url string, body string, headers map[string]string) (*http.Response, error) {
+// do sends request once and returns response with a started latency if it
+// should be counted, caller should call mark to count it.
+func (r *runner) do(bg *background, method, url string, body string, headers map[string]string, trace *reqTrace) (*http.Response, *gomark.Latency, error) {
 
 	req, err := http.NewRequest(method, url, strings.NewReader(body))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
 	for k, v := range headers {
 		req.Header.Add(k, v)
 	}
 
 	if bg.fc != nil {
-		defer bg.fc.wait().cancel()
+		pp, ok := bg.fc.wait(bg.quit)
+		if !ok {
+			return nil, nil, errStopped
+		}
+		defer pp.cancel()
 	}
+	if r.fc != nil {
+		pp, ok := r.fc.wait(bg.quit)
+		if !ok {
+			return nil, nil, errStopped
+		}
+		defer pp.cancel()
+	}
+	// trace starts after flow control so that timings exclude throttling
+	req = trace.attach(req)
+
 	var latency *gomark.Latency
 	if bg.perf != nil {
 		latency = gomark.NewLatency(bg.perf.lr)
@@ -47,10 +124,25 @@ func (r *runner) do(bg *background, method, url string, body string, headers map
 			bg.perf.adder.Mark(1)
 		}
 	}
+	if r.perf != nil {
+		r.perf.enter()
+	}
+	if bg.backend != nil {
+		bg.backend.perf.enter()
+	}
 
 	client := r.h.Get(false)
 	if client == nil {
-		return nil, errors.New("create http client fails")
+		return nil, nil, errors.New("create http client fails")
+	}
+	if _, ok := r.h.(*routineHttpcWrapper); ok {
+		client = bg.routineClient(client)
+	}
+	if bg.jar != nil {
+		// client is shared by routines, each routine uses its own jar
+		c := *client
+		c.Jar = bg.jar
+		client = &c
 	}
 
 	rsp, err := client.Do(req)
@@ -58,12 +150,32 @@ func (r *runner) do(bg *background, method, url string, body string, headers map
 	if bg.perf != nil && bg.perf.adder != nil {
 		bg.perf.adder.Mark(-1)
 	}
+	if r.perf != nil {
+		r.perf.leave()
+	}
+	if bg.backend != nil {
+		bg.backend.perf.leave()
+	}
 	// only successful request count latency
-	if err == nil && latency != nil {
-		latency.Mark()
-		bg.reportLatency(latency.Latency())
+	if err != nil {
+		return nil, nil, err
+	}
+	return rsp, latency, nil
+}
+
+// mark counts latency of a successful request
+func (r *runner) mark(bg *background, latency *gomark.Latency) {
+	if latency == nil {
+		return
+	}
+	latency.Mark()
+	bg.reportLatency(latency.Latency())
+	if r.perf != nil {
+		r.perf.mark(latency.Latency())
+	}
+	if bg.backend != nil {
+		bg.backend.perf.mark(latency.Latency())
 	}
-	return rsp, err
 }
 
 func (r *runner) run(bg *background) next {
@@ -73,6 +185,7 @@ func (r *runner) run(bg *background) next {
 		headers  map[string]string
 		decision next
 		rsp      *http.Response
+		latency  *gomark.Latency
 		err      error
 		p        provider
 	)
@@ -85,6 +198,19 @@ func (r *runner) run(bg *background) next {
 	}
 	bg.setLocalEnv(KeyTest, r.name)
 
+	// backend is chosen before URL is composed and kept for all attempts, it is
+	// ejected if HTTP execution fails on it, or it responds a 5xx status.
+	failed := false
+	if r.lb != nil {
+		be := r.lb.pick()
+		bg.backend = be
+		bg.setLocalEnv(KeyBackend, be.url)
+		defer func() {
+			r.lb.done(be, failed)
+			bg.backend = nil
+		}()
+	}
+
 	if p, decision = r.provSrc.getProvider(bg); decision != nextContinue {
 		return decision
 	}
@@ -104,51 +230,180 @@ func (r *runner) run(bg *background) next {
 	bg.setLocalEnv(KeyURL, addr)
 	bg.setLocalEnv(KeyRequest, body)
 
+	// payload is body sent on wire
+	payload := body
+	if len(r.compress) > 0 {
+		if payload, err = compressBody(r.compress, body); err != nil {
+			err = errors.Wrap(err, "compress body")
+			bg.failClass = failRequest
+			r.record(bg, 0, err)
+			return c.processFailure(bg, err)
+		}
+		headers = setHeader(headers, "Content-Encoding", r.compress, true)
+	}
+	if r.decompress {
+		headers = setHeader(headers, "Accept-Encoding", encGzip+", "+encDeflate, false)
+	}
+	bg.setLocalEnv(KeyRequestSize, strconv.Itoa(len(body)))
+	bg.setLocalEnv(KeyRequestWireSize, strconv.Itoa(len(payload)))
+
 	debug := bg.getGlobalEnv(KeyDebug) == "true"
 	method := p.getMethod(bg)
 
-	if debug {
-		fmt.Printf(`
---------Request %s-%s -------------
+	policy := r.retry
+	if policy == nil {
+		policy = defaultRetry
+	}
+	refreshed := false // if token has been refreshed for a 401
+	for attempt := 1; ; attempt++ {
+		last := attempt >= policy.attempts
+		bg.setLocalEnv(KeyAttempt, strconv.Itoa(attempt))
+		bg.failClass = ""
+		clearResponseEnv(bg)
+
+		// authentication is applied for each attempt, token may be refreshed and
+		// signature takes a new timestamp
+		sent := headers
+		if r.auth != nil {
+			if sent, err = r.auth.apply(bg, method, addr, payload, headers); err != nil {
+				err = errors.Wrap(err, "auth")
+				bg.failClass = failRequest
+				r.record(bg, 0, err)
+				return c.processFailure(bg, err)
+			}
+		}
+
+		if debug {
+			fmt.Printf(`
+--------Request %s-%s attempt %d -------------
 URL: %s %s
 Header: %v
 Body: %s
-`, bg.getLocalEnv(KeyRoutine), bg.getLocalEnv(KeySequence), method, addr, headers, body)
-	}
+`, bg.getLocalEnv(KeyRoutine), bg.getLocalEnv(KeySequence), attempt, method, addr, sent, body)
+		}
 
-	rsp, err = r.do(bg, method, addr, body, headers)
-	if err != nil {
-		_ = r.h.Get(true)
-		rsp, err = r.do(bg, method, addr, body, headers)
-	}
+		trace := &reqTrace{}
+		rsp, latency, err = r.do(bg, method, addr, payload, sent, trace)
+		r.conn(bg, trace)
+		if err == errStopped {
+			return nextFinished
+		}
+		if err != nil {
+			failed = true
+			class := classifyError(err)
+			err = errors.Wrap(err, "execute http request")
+			// backoff ends if schedule stops, and this attempt is the last
+			if !last && policy.retriable(0, class) && policy.wait(attempt, bg.quit) {
+				// client may be broken, recreate it
+				_ = r.h.Get(true)
+				bg.closeClients()
+				if policy.count {
+					bg.failClass = class
+					r.record(bg, 0, err)
+				}
+				continue
+			}
+			bg.failClass = class
+			r.record(bg, 0, err)
+			return c.processFailure(bg, err)
+		}
+		if rsp.StatusCode >= http.StatusInternalServerError {
+			failed = true
+		}
 
-	if err != nil {
-		return c.processFailure(bg, errors.Wrap(err, "execute http request"))
-	}
+		// a rejected token is refreshed and request is sent once more without
+		// taking an attempt
+		reauth := !refreshed && r.auth != nil && r.auth.rejected(rsp.StatusCode)
+		retry := reauth || (!last && policy.retriable(rsp.StatusCode, ""))
+		if !retry || policy.count {
+			r.mark(bg, latency)
+		}
 
-	b, err := ioutil.ReadAll(rsp.Body)
-	_ = rsp.Body.Close()
+		var (
+			b    []byte
+			fail error // stream event processing failure
+		)
+		wire := &countReader{ReadCloser: rsp.Body}
+		var rd io.ReadCloser = wire
+		if r.decompress {
+			rd, err = decodeBody(wire, rsp.Header.Get("Content-Encoding"))
+		}
+		if err == nil {
+			if r.stream != nil {
+				b, fail, err = r.stream.read(bg, rd, trace.start)
+			} else {
+				b, err = ioutil.ReadAll(rd)
+			}
+		}
+		_ = rsp.Body.Close()
 
-	if debug {
-		fmt.Printf(`
+		if debug {
+			fmt.Printf(`
 
---------Response %s-%s ------------
+--------Response %s-%s attempt %d ------------
 Status: %d
 Body: %s
-`, bg.getLocalEnv(KeyRoutine), bg.getLocalEnv(KeySequence), rsp.StatusCode, string(b))
-	}
-	if err != nil {
-		return c.processFailure(bg, errors.Wrap(err, "read body"))
+`, bg.getLocalEnv(KeyRoutine), bg.getLocalEnv(KeySequence), attempt, rsp.StatusCode, string(b))
+		}
+		if err != nil {
+			err = errors.Wrap(err, "read body")
+			if !retry && !last && policy.retriable(0, failBody) {
+				retry = true
+			}
+			if !retry {
+				bg.failClass = failBody
+				r.record(bg, rsp.StatusCode, err)
+				return c.processFailure(bg, err)
+			}
+		}
+		if retry && !reauth && !policy.wait(attempt, bg.quit) {
+			// schedule stops while backing off, this attempt is the last
+			retry = false
+			if !policy.count {
+				r.mark(bg, latency)
+			}
+			if err != nil {
+				bg.failClass = failBody
+				r.record(bg, rsp.StatusCode, err)
+				return c.processFailure(bg, err)
+			}
+		}
+		if retry {
+			if policy.count {
+				if err != nil {
+					bg.failClass = failBody
+				}
+				r.record(bg, rsp.StatusCode, err)
+			}
+			if reauth {
+				refreshed = true
+				r.auth.refresh(bg)
+				attempt--
+				continue
+			}
+			continue
+		}
+
+		bg.setLocalEnv(KeyStatus, strconv.Itoa(rsp.StatusCode))
+		bg.setLocalEnv(KeyResponse, string(b))
+		setResponseEnv(bg, rsp, len(b))
+		bg.setLocalEnv(KeyResponseSize, strconv.Itoa(len(b)))
+		bg.setLocalEnv(KeyResponseWireSize, strconv.FormatInt(wire.n, 10))
+		trace.setEnv(bg)
+		if fail != nil {
+			bg.failClass = failCheck
+			decision = c.processFailure(bg, fail)
+		} else {
+			decision = c.processResponse(bg)
+		}
+		r.record(bg, rsp.StatusCode, nil)
+		return decision
 	}
-	bg.setLocalEnv(KeyStatus, strconv.Itoa(rsp.StatusCode))
-	bg.setLocalEnv(KeyResponse, string(b))
-	decision = c.processResponse(bg)
-	return decision
 }
 
 // makeRunner will create a runner with valid provider.
 // if http.Client h or consumer c is not provided, a default one will be used.
-func makeRunner(name string, provSrc providerSource, h httpcFactory, c consumer) (runnable, error) {
+func makeRunner(name string, provSrc providerSource, h httpcFactory, c consumer) (*runner, error) {
 	if provSrc == nil {
 		return nil, errors.New("provider must be provided")
 	}
@@ -0,0 +1,242 @@
+package meter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/forrestjgq/glog"
+	"github.com/forrestjgq/gomark"
+	"github.com/pkg/errors"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+// maxDatagram is the max size of a UDP response
+const maxDatagram = 65535
+
+// sockRunner runs a raw TCP or UDP test, it shares provider, consumer, flow
+// control and perf with HTTP runner, while its HTTP client is not used.
+type sockRunner struct {
+	*runner
+	noReply     bool
+	framing     string
+	delimiter   []byte
+	lengthBytes int
+	readTimeout time.Duration
+	timeout     time.Duration
+}
+
+func makeSockRunner(r *runner, t *config.Test) (*sockRunner, error) {
+	sc := t.Socket
+	if err := sc.Check(); err != nil {
+		return nil, err
+	}
+	s := &sockRunner{
+		runner:      r,
+		noReply:     sc.NoReply,
+		framing:     sc.Framing,
+		delimiter:   []byte(sc.Delimiter),
+		lengthBytes: sc.LengthBytes,
+		readTimeout: 100 * time.Millisecond,
+	}
+	if len(s.framing) == 0 {
+		s.framing = config.FramingDelimiter
+	}
+	if len(s.delimiter) == 0 {
+		s.delimiter = []byte("\n")
+	}
+	if s.lengthBytes == 0 {
+		s.lengthBytes = 4
+	}
+	var err error
+	if len(sc.ReadTimeout) > 0 {
+		if s.readTimeout, err = time.ParseDuration(sc.ReadTimeout); err != nil {
+			return nil, errors.Wrapf(err, "parse read timeout %s", sc.ReadTimeout)
+		}
+	}
+	if s.timeout, err = time.ParseDuration(t.Timeout); err != nil {
+		return nil, errors.Wrapf(err, "parse timeout %s", t.Timeout)
+	}
+	return s, nil
+}
+
+// frame makes payload of TCP request by framing.
+func (s *sockRunner) frame(body string) ([]byte, error) {
+	b := []byte(body)
+	switch s.framing {
+	case config.FramingDelimiter:
+		if !bytes.HasSuffix(b, s.delimiter) {
+			b = append(b, s.delimiter...)
+		}
+	case config.FramingLength:
+		if uint64(len(b)) >= 1<<(8*uint(s.lengthBytes)) {
+			return nil, errors.Errorf("payload size %d exceeds %d length bytes", len(b), s.lengthBytes)
+		}
+		hdr := make([]byte, 4)
+		binary.BigEndian.PutUint32(hdr, uint32(len(b)))
+		b = append(hdr[4-s.lengthBytes:], b...)
+	}
+	return b, nil
+}
+
+// read reads a TCP response by framing before deadline.
+func (s *sockRunner) read(conn net.Conn, deadline time.Time) ([]byte, error) {
+	switch s.framing {
+	case config.FramingDelimiter:
+		rd := bufio.NewReader(conn)
+		last := s.delimiter[len(s.delimiter)-1]
+		var buf []byte
+		for {
+			b, err := rd.ReadSlice(last)
+			buf = append(buf, b...)
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if bytes.HasSuffix(buf, s.delimiter) {
+				return buf[:len(buf)-len(s.delimiter)], nil
+			}
+		}
+	case config.FramingLength:
+		hdr := make([]byte, 4)
+		if _, err := io.ReadFull(conn, hdr[4-s.lengthBytes:]); err != nil {
+			return nil, errors.Wrap(err, "read length")
+		}
+		b := make([]byte, binary.BigEndian.Uint32(hdr))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	default:
+		var buf bytes.Buffer
+		b := make([]byte, 4096)
+		for {
+			// wait for the first byte till deadline, then for each of following
+			// data in read timeout
+			idle := deadline
+			if buf.Len() > 0 {
+				if idle = time.Now().Add(s.readTimeout); idle.After(deadline) {
+					idle = deadline
+				}
+			}
+			_ = conn.SetReadDeadline(idle)
+			n, err := conn.Read(b)
+			buf.Write(b[:n])
+			if err == io.EOF {
+				return buf.Bytes(), nil
+			}
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() && time.Now().Before(deadline) {
+					return buf.Bytes(), nil
+				}
+				return nil, err
+			}
+		}
+	}
+}
+
+// fail counts failure err of class and processes it.
+func (s *sockRunner) fail(bg *background, class string, err error) next {
+	bg.failClass = class
+	s.record(bg, 0, err)
+	return s.c.processFailure(bg, err)
+}
+
+func (s *sockRunner) run(bg *background) next {
+	var (
+		addr     string
+		body     string
+		decision next
+		p        provider
+	)
+
+	if s.provSrc == nil || s.c == nil || bg == nil {
+		glog.Error("invalid socket runner")
+		return nextAbortAll
+	}
+	bg.setLocalEnv(KeyTest, s.name)
+
+	if p, decision = s.provSrc.getProvider(bg); decision != nextContinue {
+		return decision
+	}
+	if addr, decision = p.getUrl(bg); decision != nextContinue {
+		return decision
+	}
+	if body, decision = p.getRequestBody(bg); decision != nextContinue {
+		return decision
+	}
+	bg.setLocalEnv(KeyURL, addr)
+	bg.setLocalEnv(KeyRequest, body)
+
+	network, address := "tcp", addr[len(config.TCPPrefix):]
+	payload := []byte(body)
+	if strings.HasPrefix(addr, config.UDPPrefix) {
+		network = "udp"
+	} else {
+		var err error
+		if payload, err = s.frame(body); err != nil {
+			return s.fail(bg, failRequest, err)
+		}
+	}
+
+	if bg.fc != nil {
+		pp, ok := bg.fc.wait(bg.quit)
+		if !ok {
+			return nextFinished
+		}
+		defer pp.cancel()
+	}
+	if s.fc != nil {
+		pp, ok := s.fc.wait(bg.quit)
+		if !ok {
+			return nextFinished
+		}
+		defer pp.cancel()
+	}
+
+	var latency *gomark.Latency
+	if bg.perf != nil {
+		latency = gomark.NewLatency(bg.perf.lr)
+	}
+	deadline := time.Now().Add(s.timeout)
+	d := &net.Dialer{Deadline: deadline}
+	conn, err := d.Dial(network, address)
+	if err != nil {
+		return s.fail(bg, classifyError(err), errors.Wrap(err, "connect"))
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+
+	if _, err = conn.Write(payload); err != nil {
+		return s.fail(bg, classifyError(err), errors.Wrap(err, "send"))
+	}
+
+	var b []byte
+	if !s.noReply {
+		if network == "udp" {
+			b = make([]byte, maxDatagram)
+			var n int
+			n, err = conn.Read(b)
+			b = b[:n]
+		} else {
+			b, err = s.read(conn, deadline)
+		}
+		if err != nil {
+			return s.fail(bg, classifyError(err), errors.Wrap(err, "receive"))
+		}
+	}
+	s.mark(bg, latency)
+
+	bg.setLocalEnv(KeyResponse, string(b))
+	decision = s.c.processResponse(bg)
+	s.record(bg, 0, nil)
+	return decision
+}
@@ -0,0 +1,132 @@
+package meter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+// serveTCP serves each connection by f on a local listener
+func serveTCP(t *testing.T, f func(c net.Conn)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				f(c)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestSocket(t *testing.T) {
+	line := serveTCP(t, func(c net.Conn) {
+		s, _ := bufio.NewReader(c).ReadString('\n')
+		_, _ = c.Write([]byte(s))
+	})
+	defer line.Close()
+
+	length := serveTCP(t, func(c net.Conn) {
+		hdr := make([]byte, 2)
+		if _, err := io.ReadFull(c, hdr); err != nil {
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint16(hdr))
+		if _, err := io.ReadFull(c, b); err != nil {
+			return
+		}
+		b = append([]byte("re-"), b...)
+		binary.BigEndian.PutUint16(hdr, uint16(len(b)))
+		_, _ = c.Write(append(hdr, b...))
+	})
+	defer length.Close()
+
+	// response arrives later than read timeout in pieces and connection is
+	// kept open
+	timeout := serveTCP(t, func(c net.Conn) {
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		_, _ = c.Write([]byte("po"))
+		_, _ = c.Write([]byte("ng"))
+		_, _ = ioutil.ReadAll(c)
+	})
+	defer timeout.Close()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer udp.Close()
+	go func() {
+		b := make([]byte, 1024)
+		for {
+			n, addr, err := udp.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteTo(append([]byte("udp-"), b[:n]...), addr)
+		}
+	}()
+
+	cfg := loadFixture(t, "socket.json", "")
+	cfg.Hosts["line"].Host = config.TCPPrefix + line.Addr().String()
+	cfg.Hosts["length"].Host = config.TCPPrefix + length.Addr().String()
+	cfg.Hosts["timeout"].Host = config.TCPPrefix + timeout.Addr().String()
+	cfg.Hosts["udp"].Host = config.UDPPrefix + udp.LocalAddr().String()
+
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	expect := map[string]string{
+		"line":    `{"seq": 2}`,
+		"length":  "re-ping",
+		"timeout": "pong",
+		"udp":     "udp-ping",
+	}
+	for k, v := range expect {
+		if cr.DB[k] != v {
+			t.Errorf("expect %s response %s, got %s", k, v, cr.DB[k])
+		}
+	}
+	for _, r := range cr.Schedules[0].Tests {
+		if r.PerfStat.Requests != 2 || r.PerfStat.Count != 2 {
+			t.Errorf("expect test %s 2 requests counted, got %d", r.Name, r.PerfStat.Requests)
+		}
+	}
+
+	for _, s := range []*config.Socket{
+		{Framing: "eof"},
+		{LengthBytes: 3},
+		{ReadTimeout: "1"},
+	} {
+		if err = s.Check(); err == nil {
+			t.Errorf("expect socket %+v invalid", s)
+		}
+	}
+	for _, h := range []*config.Host{
+		{Host: "tcp://127.0.0.1"},
+		{Host: "udp://127.0.0.1:80", Proxy: "http://127.0.0.1:8080"},
+	} {
+		if err = h.Check(); err == nil {
+			t.Errorf("expect host %s invalid", h.Host)
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package meter
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,10 +40,209 @@ func loadFilePath(root string, path string) (string, error) {
 
 	return cpath, nil
 }
-func createHTTPClient(h *config.Host, timeout string) (*http.Client, error) {
-	key := h.Proxy + "|" + h.Host + "|" + timeout
+
+// absFilePath returns absolute path of a file path related to root, or path
+// itself if it could not be resolved.
+func absFilePath(root string, path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	cpath, err := loadFilePath(root, path)
+	if err != nil {
+		return path
+	}
+	if abs, err := filepath.Abs(cpath); err == nil {
+		return abs
+	}
+	return cpath
+}
+
+// tlsKey identifies a TLS profile so that hosts with different TLS settings
+// will not share a client, relative file paths are related to root.
+func tlsKey(t *config.TLS, root string) string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%v", absFilePath(root, t.CA), absFilePath(root, t.Cert),
+		absFilePath(root, t.Key), t.ServerName, t.InsecureSkipVerify)
+}
+
+// loadTLSConfig creates TLS config for an https host, relative file paths are
+// related to root.
+func loadTLSConfig(t *config.TLS, root string) (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	tc := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if len(t.CA) > 0 {
+		path, err := loadFilePath(root, t.CA)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load CA path %s", t.CA)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read CA %s", path)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no valid certificate found in CA %s", path)
+		}
+		tc.RootCAs = pool
+	}
+
+	if len(t.Cert) > 0 {
+		cert, err := loadFilePath(root, t.Cert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load cert path %s", t.Cert)
+		}
+		key, err := loadFilePath(root, t.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load key path %s", t.Key)
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load client certificate %s and key %s", cert, key)
+		}
+		tc.Certificates = []tls.Certificate{pair}
+	}
+
+	return tc, nil
+}
+
+// checkRedirect creates CheckRedirect of http.Client by redirect policy, see
+// config.Host.Redirect.
+func checkRedirect(redirect string) (func(req *http.Request, via []*http.Request) error, error) {
+	if err := config.CheckRedirect(redirect); err != nil {
+		return nil, err
+	}
+	n := 10
+	switch redirect {
+	case "", config.RedirectFollow:
+	case config.RedirectNone:
+		return func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}, nil
+	default:
+		n, _ = strconv.Atoi(redirect)
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > n {
+			return redirectLimitError(n)
+		}
+		return nil
+	}, nil
+}
+
+// transportKey identifies a transport setting so that hosts with different
+// settings will not share a client.
+func transportKey(t *config.Transport) string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d|%s|%v|%v|%v", t.MaxIdleConns, t.IdleTimeout, t.DisableKeepAlive, t.NewConnection, t.PerRoutine)
+}
+
+// tuneTransport applies transport setting of host
+func tuneTransport(transport *http.Transport, t *config.Transport) error {
+	if t.MaxIdleConns > 0 {
+		transport.MaxIdleConnsPerHost = t.MaxIdleConns
+		if transport.MaxIdleConns > 0 && transport.MaxIdleConns < t.MaxIdleConns {
+			transport.MaxIdleConns = t.MaxIdleConns
+		}
+	}
+	if len(t.IdleTimeout) > 0 {
+		du, err := time.ParseDuration(t.IdleTimeout)
+		if err != nil {
+			return errors.Wrapf(err, "parse idle timeout %s", t.IdleTimeout)
+		}
+		transport.IdleConnTimeout = du
+	}
+	transport.DisableKeepAlives = t.DisableKeepAlive
+	if t.NewConnection {
+		// negative value keeps no idle connection
+		transport.MaxIdleConnsPerHost = -1
+		// HTTP/2 sends all requests on a single connection, so HTTP/1.1 is used
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return nil
+}
+
+// dialFunc dials a connection to address
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// makeDialer creates dialer for unix socket or host with resolve overrides, or
+// nil if default dialer should be used. Relative socket path is relative to root.
+func makeDialer(h *config.Host, root string) (dialFunc, error) {
+	d := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if strings.HasPrefix(h.Host, config.UnixPrefix) {
+		path, err := loadFilePath(root, strings.TrimPrefix(h.Host, config.UnixPrefix))
+		if err != nil {
+			return nil, errors.Wrapf(err, "socket path of %s", h.Host)
+		}
+		return func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", path)
+		}, nil
+	}
+	if len(h.Resolve) == 0 {
+		return nil, nil
+	}
+
+	resolve := make(map[string]string)
+	for k, v := range h.Resolve {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			// address without port uses port of domain
+			_, port, _ := net.SplitHostPort(k)
+			v = net.JoinHostPort(v, port)
+		}
+		resolve[k] = v
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if a, ok := resolve[addr]; ok {
+			addr = a
+		}
+		return d.DialContext(ctx, network, addr)
+	}, nil
+}
+
+// hostKey identifies host address, relative socket path is related to root so
+// that sockets in different directories will not share a client.
+func hostKey(host string, root string) string {
+	if strings.HasPrefix(host, config.UnixPrefix) {
+		return config.UnixPrefix + absFilePath(root, strings.TrimPrefix(host, config.UnixPrefix))
+	}
+	return host
+}
+
+// resolveKey identifies resolve overrides so that hosts with different overrides
+// will not share a client.
+func resolveKey(resolve map[string]string) string {
+	var s []string
+	for k, v := range resolve {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func createHTTPClient(h *config.Host, timeout string, redirect string, tc *tls.Config, dial dialFunc, root string) (*http.Client, error) {
+	key := h.Proxy + "|" + hostKey(h.Host, root) + "|" + timeout + "|" + redirect + "|" + tlsKey(h.TLS, root) + "|" +
+		transportKey(h.Transport) + "|" + resolveKey(h.Resolve)
 	if host, ok := hosts[key]; !ok {
 		host := &http.Client{}
+		cr, err := checkRedirect(redirect)
+		if err != nil {
+			return nil, err
+		}
+		host.CheckRedirect = cr
 		if len(timeout) != 0 {
 			du, err := time.ParseDuration(timeout)
 			if err != nil {
@@ -45,14 +250,30 @@ func createHTTPClient(h *config.Host, timeout string) (*http.Client, error) {
 			}
 			host.Timeout = du
 		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// encoding is handled by gmeter, see Test.Decompress, so that response is
+		// never decompressed transparently
+		transport.DisableCompression = true
+		if tc != nil {
+			transport.TLSClientConfig = tc
+		}
 		if len(h.Proxy) > 0 {
-			proxy := func(_ *http.Request) (*url.URL, error) {
+			transport.Proxy = func(_ *http.Request) (*url.URL, error) {
 				return url.Parse(h.Proxy)
 			}
-			transport := &http.Transport{
-				Proxy: proxy,
+		}
+		host.Transport = transport
+		if dial != nil {
+			transport.DialContext = dial
+			if len(h.Proxy) == 0 {
+				// connect to target directly
+				transport.Proxy = nil
+			}
+		}
+		if h.Transport != nil {
+			if err = tuneTransport(transport, h.Transport); err != nil {
+				return nil, err
 			}
-			host.Transport = transport
 		}
 		hosts[key] = host
 		return host, nil
@@ -60,7 +281,9 @@ func createHTTPClient(h *config.Host, timeout string) (*http.Client, error) {
 		return host, nil
 	}
 }
-func loadHTTPClient(t *config.Test, s *config.Schedule, cfg *config.Config) (httpcFactory, string, error) {
+
+// loadHost finds host of test and fills default timeout of test.
+func loadHost(t *config.Test, s *config.Schedule, cfg *config.Config) (*config.Host, error) {
 	if t.Host == "" {
 		if len(cfg.Hosts) == 1 {
 			for k := range cfg.Hosts {
@@ -76,7 +299,7 @@ func loadHTTPClient(t *config.Test, s *config.Schedule, cfg *config.Config) (htt
 	if !ok {
 		urls := strings.Split(t.Host, "|")
 		if len(urls) == 0 || len(urls) > 2 {
-			return nil, "", errors.Errorf("unknown host definition: %s", t.Host)
+			return nil, errors.Errorf("unknown host definition: %s", t.Host)
 		}
 		h = &config.Host{}
 
@@ -88,7 +311,7 @@ func loadHTTPClient(t *config.Test, s *config.Schedule, cfg *config.Config) (htt
 		}
 	}
 	if err := h.Check(); err != nil {
-		return nil, "", errors.Wrapf(err, "host %s check", t.Host)
+		return nil, errors.Wrapf(err, "host %s check", t.Host)
 	}
 
 	if len(t.Timeout) == 0 {
@@ -99,9 +322,42 @@ func loadHTTPClient(t *config.Test, s *config.Schedule, cfg *config.Config) (htt
 	if len(t.Timeout) == 0 {
 		t.Timeout = "1m"
 	}
+	return h, nil
+}
+
+func loadHTTPClient(t *config.Test, s *config.Schedule, cfg *config.Config) (httpcFactory, string, error) {
+	h, err := loadHost(t, s, cfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tc, err := loadTLSConfig(h.TLS, cfg.Options[config.OptionCfgPath])
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "host %s load TLS", t.Host)
+	}
+
+	redirect := t.Redirect
+	if len(redirect) == 0 {
+		redirect = h.Redirect
+	}
+	if err = config.CheckRedirect(redirect); err != nil {
+		return nil, "", errors.Wrapf(err, "test redirect")
+	}
+
+	dial, err := makeDialer(h, cfg.Options[config.OptionCfgPath])
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "host %s dialer", t.Host)
+	}
+	host := h.Host
+	if strings.HasPrefix(host, config.UnixPrefix) {
+		host = "http://localhost"
+	} else if len(h.Backends) > 0 {
+		// runner chooses a backend for each request
+		host = "$(" + KeyBackend + ")"
+	}
 
 	creator := func() *http.Client {
-		c, err := createHTTPClient(h, t.Timeout)
+		c, err := createHTTPClient(h, t.Timeout, redirect, tc, dial, cfg.Options[config.OptionCfgPath])
 		if err != nil {
 			fmt.Printf("create http client failed: %v", err)
 			return nil
@@ -109,7 +365,10 @@ func loadHTTPClient(t *config.Test, s *config.Schedule, cfg *config.Config) (htt
 		return c
 	}
 	factory := createConcurrentHttpClientWrapper(creator)
-	return factory, h.Host, nil
+	if h.Transport != nil && h.Transport.PerRoutine {
+		factory = &routineHttpcWrapper{factory}
+	}
+	return factory, host, nil
 }
 
 // create a test from a base.
@@ -138,6 +397,24 @@ func constructTest(t, base *config.Test) (*config.Test, error) {
 	if len(t.Timeout) == 0 && len(base.Timeout) > 0 {
 		t.Timeout = base.Timeout
 	}
+	if len(t.Redirect) == 0 && len(base.Redirect) > 0 {
+		t.Redirect = base.Redirect
+	}
+	if t.Retry == nil && base.Retry != nil {
+		t.Retry = base.Retry
+	}
+	if len(t.Compress) == 0 && len(base.Compress) > 0 {
+		t.Compress = base.Compress
+	}
+	if !t.Decompress && base.Decompress {
+		t.Decompress = base.Decompress
+	}
+	if t.Auth == nil && base.Auth != nil {
+		t.Auth = base.Auth
+	}
+	if t.ThinkTime == nil && base.ThinkTime != nil {
+		t.ThinkTime = base.ThinkTime
+	}
 	if t.Response == nil {
 		if base.Response != nil {
 			t.Response = base.Response
@@ -203,20 +480,46 @@ func loadRequest(t *config.Test, cfg *config.Config) (*config.Request, error) {
 	return req, nil
 }
 func loadProvider(host string, t *config.Test, s *config.Schedule, cfg *config.Config) (providerSource, error) {
-	req, err := loadRequest(t, cfg)
-	if err != nil {
+	var req *config.Request
+	var err error
+	if ws := t.WebSocket; ws != nil {
+		// handshake request
+		req = &config.Request{Method: http.MethodGet, Path: ws.Path, Headers: ws.Headers}
+	} else if sc := t.Socket; sc != nil {
+		// payload is composed as text body
+		req = &config.Request{Method: http.MethodGet, Text: sc.Send}
+	} else if req, err = loadRequest(t, cfg); err != nil {
 		return nil, errors.Wrap(err, "load request")
 	}
 
 	m := make(map[string]string)
 	m[string(catMethod)] = req.Method
 	m[string(catURL)] = host + req.Path
-	m[string(catBody)] = string(req.Body)
+	hasContentType := false
 	for k, v := range req.Headers {
 		m[k] = v
+		if strings.EqualFold(k, "Content-Type") {
+			hasContentType = true
+		}
+	}
+
+	body, contentType, err := makeBodySource(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "load body")
+	}
+	if len(contentType) > 0 && !hasContentType {
+		m["Content-Type"] = contentType
+	}
+
+	source := make(map[string]feedSource)
+	for k, v := range m {
+		if source[k], err = makeSegments(v); err != nil {
+			return nil, errors.Wrapf(err, "compile %s", k)
+		}
 	}
+	source[string(catBody)] = body
 
-	feeder, err := makeDynamicFeeder(m, s.Count, t.PreProcess)
+	feeder, err := makeSourceFeeder(source, s.Count, t.PreProcess)
 	if err != nil {
 		return nil, errors.Wrap(err, "create feeder")
 	}
@@ -241,6 +544,56 @@ func loadConsumer(t *config.Test, cfg *config.Config) (consumer, error) {
 	}
 	return csm, nil
 }
+
+// uncounted returns a copy of schedule s whose Count is 0, providers loaded by it
+// never finish by count.
+func uncounted(s *config.Schedule) *config.Schedule {
+	c := *s
+	c.Count = 0
+	return &c
+}
+
+// loadTokenSource loads token source of t, tests of a schedule caching token at
+// the same db key share one in tokens.
+func loadTokenSource(t *config.Token, s *config.Schedule, cfg *config.Config, tokens map[string]*tokenSource) (*tokenSource, error) {
+	key := t.Key
+	if len(key) == 0 {
+		key = "TOKEN." + t.Test
+	}
+	if ts, ok := tokens[key]; ok {
+		return ts, nil
+	}
+	tt, ok := cfg.Tests[t.Test]
+	if !ok || tt == nil {
+		return nil, errors.Errorf("token test %s not found", t.Test)
+	}
+	// token test may be run in other schedules, and its host may be set
+	tt = clone.Clone(tt).(*config.Test)
+	client, host, err := loadHTTPClient(tt, s, cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "token test %s load host", t.Test)
+	}
+	// token test runs whenever token expires regardless of Schedule.Count
+	prv, err := loadProvider(host, tt, uncounted(s), cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "token test %s load provider", t.Test)
+	}
+	csm, err := loadConsumer(tt, cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "token test %s load consumer", t.Test)
+	}
+	r, err := makeRunner(t.Test, prv, client, csm)
+	if err != nil {
+		return nil, errors.Wrapf(err, "make token test %s runner", t.Test)
+	}
+	ts, err := makeTokenSource(t, key, r)
+	if err != nil {
+		return nil, err
+	}
+	tokens[key] = ts
+	return ts, nil
+}
+
 func loadPlan(cfg *config.Config, s *config.Schedule) (*plan, error) {
 	var err error
 
@@ -296,6 +649,31 @@ func loadPlan(cfg *config.Config, s *config.Schedule) (*plan, error) {
 		tests = filtered
 	}
 
+	// tests of all branches of a mix are loaded in order, and their runners are
+	// split by branch later
+	var branches []int // count of tests of each branch
+	if len(s.Mix) > 0 {
+		if len(tests) > 0 {
+			return nil, errors.Errorf("schedule %s defines both Tests and Mix", s.Name)
+		}
+		for i, m := range s.Mix {
+			if m == nil || m.Weight < 0 {
+				return nil, errors.Errorf("schedule %s mix %d invalid", s.Name, i)
+			}
+			n := 0
+			for _, t := range strings.Split(m.Tests, "|") {
+				if t = strings.TrimSpace(t); len(t) > 0 {
+					tests = append(tests, t)
+					n++
+				}
+			}
+			if n == 0 {
+				return nil, errors.Errorf("schedule %s mix %d contains no tests", s.Name, i)
+			}
+			branches = append(branches, n)
+		}
+	}
+
 	if len(tests) == 0 {
 		return nil, errors.Errorf("schedule %s contains no tests", s.Name)
 	}
@@ -310,6 +688,11 @@ func loadPlan(cfg *config.Config, s *config.Schedule) (*plan, error) {
 	}
 
 	var runners []runnable
+	var testNames []string
+	testPerf := make(map[string]*perf)
+	balancers := make(map[string]*balancer) // host key -> balancer
+	var lbs []*balancer
+	tokens := make(map[string]*tokenSource) // db key -> token source
 	for _, name := range tests {
 		t, ok := cfg.Tests[name]
 		if !ok || t == nil {
@@ -327,8 +710,19 @@ func loadPlan(cfg *config.Config, s *config.Schedule) (*plan, error) {
 		if err != nil {
 			return nil, errors.Wrapf(err, "config %s schedule %s test %s load host", cfg.Name, s.Name, name)
 		}
+		if t.WebSocket != nil {
+			host = wsHost(host)
+		}
+		if config.IsSocketHost(host) != (t.Socket != nil) {
+			return nil, errors.Errorf("test %s: socket test must be run on tcp or udp host and vice versa", name)
+		}
 
-		prv, err := loadProvider(host, t, s, cfg)
+		// mix counts iterations instead of its tests
+		ps := s
+		if len(branches) > 0 {
+			ps = uncounted(s)
+		}
+		prv, err := loadProvider(host, t, ps, cfg)
 		if err != nil {
 			return nil, errors.Wrapf(err, "config %s schedule %s test %s load provider", cfg.Name, s.Name, name)
 		}
@@ -342,20 +736,175 @@ func loadPlan(cfg *config.Config, s *config.Schedule) (*plan, error) {
 		if err != nil {
 			return nil, errors.Wrapf(err, "make test %s runner", name)
 		}
-		runners = append(runners, runner)
+		if t.QPS > 0 {
+			runner.fc = makeFlowControl(t.QPS, t.Burst, 0)
+		}
+		if t.Retry != nil {
+			if runner.retry, err = makeRetryPolicy(t.Retry); err != nil {
+				return nil, errors.Wrapf(err, "test %s retry", name)
+			}
+		}
+		if len(t.Compress) > 0 {
+			if err = checkEncoding(t.Compress); err != nil {
+				return nil, errors.Wrapf(err, "test %s compress", name)
+			}
+			runner.compress = t.Compress
+		}
+		runner.decompress = t.Decompress
+		if t.Response != nil && t.Response.Stream != nil {
+			if runner.stream, err = makeStreamReader(t.Response.Stream); err != nil {
+				return nil, errors.Wrapf(err, "test %s stream", name)
+			}
+		}
+		auth := t.Auth
+		if h, ok := cfg.Hosts[t.Host]; ok && auth == nil {
+			auth = h.Auth
+		}
+		if auth != nil {
+			if runner.auth, err = makeAuthenticator(auth); err != nil {
+				return nil, errors.Wrapf(err, "test %s auth", name)
+			}
+			if auth.Token != nil {
+				if runner.auth.token, err = loadTokenSource(auth.Token, s, cfg, tokens); err != nil {
+					return nil, errors.Wrapf(err, "test %s auth", name)
+				}
+			}
+		}
+		// tests of a schedule on the same host share its balancer
+		if h, ok := cfg.Hosts[t.Host]; ok && len(h.Backends) > 0 {
+			lb, ok := balancers[t.Host]
+			if !ok {
+				if lb, err = makeBalancer(s.Name, t.Host, h); err != nil {
+					return nil, errors.Wrapf(err, "test %s balancer", name)
+				}
+				balancers[t.Host] = lb
+				lbs = append(lbs, lb)
+			}
+			runner.lb = lb
+		}
+		// a test may appear more than once in a schedule, they share the same perf
+		if tp, ok := testPerf[name]; ok {
+			runner.perf = tp
+		} else {
+			runner.perf = makePerf(s.Name + "_" + name)
+			runner.perf.prom = prom.clientSeries(cfg.Name, s.Name, name)
+			testPerf[name] = runner.perf
+			testNames = append(testNames, name)
+		}
+		if t.WebSocket != nil {
+			ws, err := makeWsRunner(runner, t, s, cfg)
+			if err != nil {
+				return nil, errors.Wrapf(err, "test %s WebSocket", name)
+			}
+			runners = append(runners, ws)
+		} else if t.Socket != nil {
+			sr, err := makeSockRunner(runner, t)
+			if err != nil {
+				return nil, errors.Wrapf(err, "test %s socket", name)
+			}
+			runners = append(runners, sr)
+		} else {
+			runners = append(runners, runner)
+		}
+		if t.ThinkTime != nil {
+			last := len(runners) - 1
+			if runners[last], err = makeThinker(name, runners[last], t.ThinkTime); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if len(runners) == 0 {
 		return nil, errors.Errorf("schedule %s does not define any tests", s.Name)
 	}
 
+	var mix *mixer
 	run := assembleRunners(runners...)
+	if len(branches) > 0 {
+		mix = &mixer{count: s.Count}
+		for i, n := range branches {
+			mix.add(s.Mix[i].Tests, s.Mix[i].Weight, assembleRunners(runners[:n]...))
+			runners = runners[n:]
+		}
+		run = mix
+	}
 
 	p := &plan{
 		name:       s.Name,
 		target:     run,
 		bg:         nil,
 		concurrent: s.Concurrency,
+		qps:        s.QPS,
+		tests:      testNames,
+		testPerf:   testPerf,
+		balancers:  lbs,
+		mix:        mix,
+		quit:       make(chan struct{}),
+	}
+
+	if s.ThinkTime != nil {
+		if p.think, err = makeThinkTime(s.ThinkTime); err != nil {
+			return nil, errors.Wrapf(err, "schedule %s think time", s.Name)
+		}
+	}
+	if len(s.Pacing) > 0 {
+		p.pacing, err = time.ParseDuration(s.Pacing)
+		if err != nil || p.pacing <= 0 {
+			return nil, errors.Errorf("schedule %s: invalid pacing %s", s.Name, s.Pacing)
+		}
+	}
+
+	if len(s.Duration) > 0 {
+		p.duration, err = time.ParseDuration(s.Duration)
+		if err != nil {
+			return nil, errors.Wrapf(err, "schedule %s parse duration %s", s.Name, s.Duration)
+		}
+	}
+	for i, st := range s.Stages {
+		if st == nil {
+			continue
+		}
+		du, err := time.ParseDuration(st.Duration)
+		if err != nil || du <= 0 {
+			return nil, errors.Errorf("schedule %s stage %d: invalid duration %s", s.Name, i, st.Duration)
+		}
+		if st.Concurrency < 0 || st.QPS < 0 {
+			return nil, errors.Errorf("schedule %s stage %d: negative concurrency or QPS", s.Name, i)
+		}
+		p.stages = append(p.stages, stage{
+			du:          du,
+			concurrency: st.Concurrency,
+			qps:         st.QPS,
+		})
+	}
+
+	if len(s.PerfReport) > 0 {
+		p.perfReport, err = loadFilePath(cfg.Options[config.OptionCfgPath], s.PerfReport)
+		if err != nil {
+			return nil, errors.Wrapf(err, "schedule %s load perf report path", s.Name)
+		}
+	}
+
+	if a := s.Arrival; a != nil {
+		if a.Rate <= 0 {
+			return nil, errors.Errorf("schedule %s arrival rate %v invalid", s.Name, a.Rate)
+		}
+		p.arrival = &arrival{
+			rate:        a.Rate,
+			maxInFlight: a.MaxInFlight,
+			drop:        a.DropLate,
+		}
+		switch a.Model {
+		case "", config.ArrivalConstant:
+		case config.ArrivalPoisson:
+			p.arrival.poisson = true
+		default:
+			return nil, errors.Errorf("schedule %s arrival model %s unknown", s.Name, a.Model)
+		}
+		if p.arrival.maxInFlight <= 0 {
+			p.arrival.maxInFlight = 1000
+		}
+		p.stages = nil
 	}
 
 	p.preprocess, _, err = makeComposable(s.PreProcess)
@@ -385,14 +934,29 @@ func create(cfg *config.Config) ([]*plan, error) {
 			s.Count = math.MaxUint64 - 1
 		}
 
-		if s.Concurrency > 1 {
-			if s.Parallel > s.Concurrency {
-				s.Parallel = s.Concurrency
+		// max concurrency may be reached in stages
+		concurrency := s.Concurrency
+		stageQPS := false
+		for _, st := range s.Stages {
+			if st != nil {
+				if st.Concurrency > concurrency {
+					concurrency = st.Concurrency
+				}
+				if st.QPS > 0 {
+					stageQPS = true
+				}
+			}
+		}
+		if s.Concurrency < 1 {
+			s.Concurrency = 1
+		}
+		if concurrency > 1 {
+			if s.Parallel > concurrency {
+				s.Parallel = concurrency
 			} else if s.Parallel < 2 {
 				s.Parallel = 0
 			}
 		} else {
-			s.Concurrency = 1
 			s.Parallel = 0
 		}
 
@@ -407,8 +971,9 @@ func create(cfg *config.Config) ([]*plan, error) {
 		}
 
 		p.bg.functions = functions
-		if s.QPS > 1 || s.Parallel > 1 {
-			p.fc = makeFlowControl(s.QPS, s.Parallel)
+		p.bg.quit = p.quit
+		if s.QPS > 0 || s.Parallel > 1 || stageQPS {
+			p.fc = makeFlowControl(s.QPS, s.Burst, s.Parallel)
 			p.bg.fc = p.fc
 		}
 
@@ -517,10 +1082,32 @@ func override(template, cfg *config.Config) {
 		cfg.Functions[k] = v
 	}
 }
+
+// StartConfig runs a config and returns error if any schedule fails.
 func StartConfig(cfg *config.Config) error {
+	_, err := runConfig(cfg)
+	return err
+}
+
+// runConfig runs a config and returns its result, which is always valid even if
+// error is returned.
+func runConfig(cfg *config.Config) (res *config.ConfigResult, err error) {
+	res = &config.ConfigResult{
+		Name:  cfg.Name,
+		Start: time.Now(),
+	}
+	defer func() {
+		res.End = time.Now()
+		res.Success = err == nil
+		if err != nil {
+			res.Error = err.Error()
+		}
+		res.DB = snapshot(createDB())
+	}()
+
 	imports, err := iface2strings(cfg.Imports)
 	if err != nil {
-		return errors.Wrapf(err, "convert imports to strings")
+		return res, errors.Wrapf(err, "convert imports to strings")
 	}
 	for _, base := range imports {
 		if len(base) == 0 {
@@ -532,7 +1119,7 @@ func StartConfig(cfg *config.Config) error {
 		}
 		baseCfg, err := loadCfg(root, base)
 		if err != nil {
-			return errors.Wrapf(err, "load config %s from %s", base, root)
+			return res, errors.Wrapf(err, "load config %s from %s", base, root)
 		}
 		for _, t := range baseCfg.Tests {
 			t.SetImported()
@@ -542,7 +1129,7 @@ func StartConfig(cfg *config.Config) error {
 
 	plans, err := create(cfg)
 	if err != nil {
-		return errors.Wrapf(err, "create test")
+		return res, errors.Wrapf(err, "create test")
 	}
 
 	type result struct {
@@ -579,6 +1166,7 @@ func StartConfig(cfg *config.Config) error {
 
 	for _, p := range plans {
 		p.close()
+		res.Schedules = append(res.Schedules, p.result)
 	}
 
 	fmt.Println("--------------------------------")
@@ -594,11 +1182,43 @@ func StartConfig(cfg *config.Config) error {
 		}
 		fmt.Printf("\t%s: %s\n", k, str)
 	}
+	for _, p := range plans {
+		if st := p.stat; st != nil && st.Count > 0 {
+			fmt.Printf("\t%s: count %d qps %d latency(us) avg %d min %d max %d p50 %d p90 %d p95 %d p99 %d p99.9 %d\n",
+				p.name, st.Count, st.QPS, st.Avg, st.Min, st.Max, st.P50, st.P90, st.P95, st.P99, st.P999)
+		}
+		for _, m := range p.mixResults {
+			fmt.Printf("\t\tmix %s: weight %d picked %d ratio %.2f%%\n", m.Tests, m.Weight, m.Picked, m.Ratio*100)
+		}
+		// backends are listed after tests, named like "host|backend"
+		tests := append(append([]*config.TestResult(nil), p.testResults...), p.lbResults...)
+		for _, t := range tests {
+			st := t.PerfStat
+			if st.Requests == 0 {
+				continue
+			}
+			fmt.Printf("\t\t%s: requests %d errors %d", t.Name, st.Requests, st.Errors)
+			if len(st.Status) > 0 {
+				fmt.Printf(" status %s", statusString(st.Status))
+			}
+			if len(st.Classes) > 0 {
+				fmt.Printf(" failures %s", classString(st.Classes))
+			}
+			if st.Connections+st.Reused > 0 {
+				fmt.Printf(" conns new %d reused %d", st.Connections, st.Reused)
+			}
+			fmt.Println()
+			if st.Count > 0 {
+				fmt.Printf("\t\t\tlatency(us) avg %d min %d max %d p50 %d p90 %d p95 %d p99 %d p99.9 %d\n",
+					st.Avg, st.Min, st.Max, st.P50, st.P90, st.P95, st.P99, st.P999)
+			}
+		}
+	}
 
 	if failed {
-		return errors.Errorf("failed schedules: %v", cases)
+		return res, errors.Errorf("failed schedules: %v", cases)
 	}
-	return nil
+	return res, nil
 }
 
 // Start a test, path is the configure json file path, which must be able to be
@@ -1,10 +1,14 @@
 package meter_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -576,3 +580,142 @@ func TestStartTestSeq(t *testing.T) {
 	}
 
 }
+func TestStartTLS(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(401)
+			return
+		}
+		// client keeps HTTP/2 support of default transport
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		_, _ = w.Write([]byte("pong"))
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	defer s.Close()
+
+	// server certificate acts as both CA and client certificate
+	dir := t.TempDir()
+	cert := s.TLS.Certificates[0]
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_ = ioutil.WriteFile(dir+"/ca.pem", crt, os.ModePerm)
+	_ = ioutil.WriteFile(dir+"/cert.pem", crt, os.ModePerm)
+	_ = ioutil.WriteFile(dir+"/key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), os.ModePerm)
+
+	b, err := readExample("tls.json")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cfg := &config.Config{}
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cfg.Hosts["secure"].Host = s.URL
+	cfg.Options[config.OptionCfgPath] = dir
+	err = meter.StartConfig(cfg)
+	if err != nil {
+		t.Fatalf("failed: %+v", err)
+	}
+
+	// without client certificate, server rejects
+	cfg.Hosts["secure"].TLS.Cert = ""
+	cfg.Hosts["secure"].TLS.Key = ""
+	err = meter.StartConfig(cfg)
+	if err == nil {
+		t.Fatalf("expect a failure without client certificate")
+	}
+
+	// SNI mismatch fails verification unless verification is skipped
+	cfg.Hosts["secure"].TLS = &config.TLS{
+		CA:         "ca.pem",
+		Cert:       "cert.pem",
+		Key:        "key.pem",
+		ServerName: "gmeter.invalid",
+	}
+	err = meter.StartConfig(cfg)
+	if err == nil {
+		t.Fatalf("expect a failure for server name mismatch")
+	}
+
+	cfg.Hosts["secure"].TLS.InsecureSkipVerify = true
+	err = meter.StartConfig(cfg)
+	if err != nil {
+		t.Fatalf("failed: %+v", err)
+	}
+}
+func TestStartPerfReport(t *testing.T) {
+	m := &mockServer{}
+	err := m.start("")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	defer m.stop()
+
+	b, err := readExample("perf.json")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cfg := &config.Config{}
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	dir := t.TempDir()
+	cfg.Hosts["-"].Host = "http://127.0.0.1:" + m.port
+	cfg.Options[config.OptionCfgPath] = dir
+	err = meter.StartConfig(cfg)
+	if err != nil {
+		t.Fatalf("failed: %+v", err)
+	}
+
+	b, err = ioutil.ReadFile(dir + "/perf/result.json")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var res struct {
+		Schedule string
+		Count    int64
+		P50      int64
+		P99      int64
+		Buckets  []struct {
+			From, To, Count int64
+		}
+		Tests map[string]struct {
+			Requests int64
+			Errors   int64
+			Status   map[int]int64
+			Count    int64
+		}
+	}
+	err = json.Unmarshal(b, &res)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if res.Schedule != "perf" || res.Count == 0 || res.P50 > res.P99 || len(res.Buckets) == 0 {
+		t.Fatalf("invalid perf report: %s", string(b))
+	}
+	ping, missing := res.Tests["ping"], res.Tests["missing"]
+	if ping.Requests != 100 || ping.Errors != 0 || ping.Status[200] != 100 || ping.Count != 100 {
+		t.Fatalf("invalid ping perf: %+v", ping)
+	}
+	if missing.Requests != 100 || missing.Errors != 0 || missing.Status[404] != 100 {
+		t.Fatalf("invalid missing perf: %+v", missing)
+	}
+	if res.Count != ping.Count+missing.Count {
+		t.Fatalf("schedule count %d mismatch tests", res.Count)
+	}
+}
@@ -0,0 +1,162 @@
+package meter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+	"github.com/pkg/errors"
+)
+
+// streamReader reads a streaming response event by event.
+type streamReader struct {
+	sse      bool
+	event    composable
+	until    composable
+	count    int
+	duration time.Duration
+}
+
+func makeStreamReader(s *config.Stream) (*streamReader, error) {
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+	r := &streamReader{
+		sse:   s.Type == config.StreamSSE,
+		count: s.Count,
+	}
+	var err error
+	if r.event, _, err = makeComposable(s.Event); err != nil {
+		return nil, errors.Wrapf(err, "stream event")
+	}
+	if r.until, _, err = makeComposable(s.Until); err != nil {
+		return nil, errors.Wrapf(err, "stream until")
+	}
+	if len(s.Duration) > 0 {
+		if r.duration, err = time.ParseDuration(s.Duration); err != nil {
+			return nil, errors.Wrapf(err, "parse stream duration %s", s.Duration)
+		}
+	}
+	return r, nil
+}
+
+// sseEvent is an event being parsed from Server-Sent Events.
+type sseEvent struct {
+	typ, id string
+	data    []string
+}
+
+// feed parses a line of SSE, and returns true if an event is dispatched.
+func (e *sseEvent) feed(line string) bool {
+	if len(line) == 0 {
+		return len(e.data) > 0
+	}
+	if strings.HasPrefix(line, ":") {
+		return false // comment
+	}
+	field, value := line, ""
+	if i := strings.Index(line, ":"); i >= 0 {
+		field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+	}
+	switch field {
+	case "data":
+		e.data = append(e.data, value)
+	case "event":
+		e.typ = value
+	case "id":
+		e.id = value
+	}
+	return false
+}
+
+// read reads events from body until stream stops, start is when request starts.
+// It returns data of events read, fail reports the error of event processing,
+// and err reports the error reading body.
+func (s *streamReader) read(bg *background, body io.ReadCloser, start time.Time) (b []byte, fail error, err error) {
+	var expired int32
+	if s.duration > 0 {
+		// closing body makes a blocking read return
+		t := time.AfterFunc(s.duration, func() {
+			atomic.StoreInt32(&expired, 1)
+			_ = body.Close()
+		})
+		defer t.Stop()
+	}
+
+	var events []string
+	bg.setLocalEnv(KeyStreamStop, "")
+	dispatch := func(data, typ, id string) (bool, error) {
+		events = append(events, data)
+		n := len(events)
+		if n == 1 {
+			bg.setLocalEnv(KeyTimeFirstEvent, us(time.Since(start)))
+		}
+		bg.setLocalEnv(KeyEvent, data)
+		bg.setLocalEnv(KeyEventIndex, strconv.Itoa(n))
+		if s.sse {
+			bg.setLocalEnv(KeyEventType, typ)
+			bg.setLocalEnv(KeyEventID, id)
+		}
+		if s.event != nil {
+			if _, err := s.event.compose(bg); err != nil {
+				return true, errors.Wrapf(err, "event %d", n)
+			}
+			if bg.getLocalEnv(KeyStreamStop) == "true" {
+				return true, nil
+			}
+		}
+		if s.until != nil {
+			out, err := s.until.compose(bg)
+			if err != nil {
+				return true, errors.Wrapf(err, "event %d until", n)
+			}
+			if out == "true" {
+				return true, nil
+			}
+		}
+		return s.count > 0 && n >= s.count, nil
+	}
+
+	var ev sseEvent
+	rd := bufio.NewReader(body)
+	for {
+		line, rerr := rd.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		stop := false
+		if s.sse {
+			// a line ended by error is incomplete, and so is the event being
+			// parsed, which is discarded as SSE requires
+			if rerr == nil && ev.feed(line) {
+				stop, fail = dispatch(strings.Join(ev.data, "\n"), ev.typ, ev.id)
+				ev = sseEvent{}
+			}
+		} else if len(line) > 0 {
+			stop, fail = dispatch(line, "", "")
+		}
+		if stop {
+			break
+		}
+		if rerr != nil {
+			if rerr != io.EOF && atomic.LoadInt32(&expired) == 0 {
+				err = rerr
+			}
+			break
+		}
+	}
+
+	bg.setLocalEnv(KeyEvents, strconv.Itoa(len(events)))
+	if len(events) > 0 {
+		var buf bytes.Buffer
+		for _, e := range events {
+			buf.WriteString(e)
+			buf.WriteByte('\n')
+		}
+		b = buf.Bytes()
+	}
+	return b, fail, err
+}
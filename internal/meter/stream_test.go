@@ -0,0 +1,86 @@
+package meter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var chunks []string
+		switch r.URL.Path {
+		case "/sse":
+			w.Header().Set("Content-Type", "text/event-stream")
+			chunks = []string{
+				": comment\n\n",
+				"event: tick\nid: 1\ndata: a\ndata: b\n\n",
+				"event: tock\nid: 2\ndata: c\n\n",
+				"data: d\n\n",
+			}
+		case "/partial":
+			w.Header().Set("Content-Type", "text/event-stream")
+			chunks = []string{"data: a\n\n", "data: b\n"}
+		case "/line":
+			chunks = []string{"a\n", "b\r\n", "\n", "c\n", "d"}
+		case "/slow":
+			chunks = []string{"a\n"}
+		}
+		for _, c := range chunks {
+			_, _ = w.Write([]byte(c))
+			w.(http.Flusher).Flush()
+		}
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	cr, err := runFixture(t, "stream.json", srv.URL)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	expect := map[string]string{
+		"sse":     "2",
+		"sse.1":   "tick-1",
+		"sse.2":   "tock-2",
+		"line":    "3",
+		"stop":    "2",
+		"fail":    "2",
+		"partial": "1",
+		"slow":    "1",
+	}
+	for k, v := range expect {
+		if cr.DB[k] != v {
+			t.Errorf("expect %s %s, got %s", k, v, cr.DB[k])
+		}
+	}
+	if len(cr.DB["first"]) == 0 || cr.DB["first"] == "0" {
+		t.Errorf("expect time to first event, got %s", cr.DB["first"])
+	}
+
+	// time to first event excludes waiting for QPS of schedule
+	cfg := loadFixture(t, "stream.json", srv.URL)
+	cfg.Schedules[0].Tests = "sse"
+	cfg.Schedules[0].Count = 2
+	cfg.Schedules[0].QPS = 4
+	if cr, err = runConfig(cfg); err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	if first, err := strconv.Atoi(cr.DB["first"]); err != nil || first >= 125000 {
+		t.Errorf("expect time to first event less than half of pacing interval, got %s", cr.DB["first"])
+	}
+
+	for _, s := range []*config.Stream{
+		{Type: "ws"},
+		{Count: -1},
+		{Duration: "1"},
+	} {
+		if err = s.Check(); err == nil {
+			t.Errorf("expect stream %+v invalid", s)
+		}
+	}
+}
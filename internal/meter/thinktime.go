@@ -0,0 +1,94 @@
+package meter
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+// thinkTime is a random pause simulating a user.
+type thinkTime struct {
+	model    string
+	du       time.Duration // fixed pause, or mean of exponential
+	min, max time.Duration
+}
+
+func makeThinkTime(t *config.ThinkTime) (*thinkTime, error) {
+	if err := t.Check(); err != nil {
+		return nil, err
+	}
+	tt := &thinkTime{model: t.Model}
+	// durations are checked
+	if len(t.Duration) > 0 {
+		tt.du, _ = time.ParseDuration(t.Duration)
+	}
+	if len(t.Min) > 0 {
+		tt.min, _ = time.ParseDuration(t.Min)
+	}
+	if len(t.Max) > 0 {
+		tt.max, _ = time.ParseDuration(t.Max)
+	}
+	return tt, nil
+}
+
+// delay returns a random pause.
+func (t *thinkTime) delay() time.Duration {
+	switch t.model {
+	case config.ThinkUniform:
+		return t.min + time.Duration(rand.Int63n(int64(t.max-t.min)+1))
+	case config.ThinkExponential:
+		d := time.Duration(rand.ExpFloat64() * float64(t.du))
+		if t.max > 0 && d > t.max {
+			d = t.max
+		}
+		return d
+	}
+	return t.du
+}
+
+// pause sleeps for d, it returns false if quit is closed before d passes.
+func pause(d time.Duration, quit <-chan struct{}) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// thinker pauses for think time after its target succeeds, the pause is outside
+// of target so it's never counted in latency.
+type thinker struct {
+	target runnable
+	think  *thinkTime
+}
+
+func (t *thinker) close() {
+	t.target.close()
+}
+
+// implements runnable
+func (t *thinker) run(bg *background) next {
+	decision := t.target.run(bg)
+	if decision == nextContinue {
+		pause(t.think.delay(), bg.quit)
+	}
+	return decision
+}
+
+// makeThinker makes think time of test name after target.
+func makeThinker(name string, target runnable, t *config.ThinkTime) (runnable, error) {
+	tt, err := makeThinkTime(t)
+	if err != nil {
+		return nil, errors.Wrapf(err, "test %s think time", name)
+	}
+	return &thinker{target: target, think: tt}, nil
+}
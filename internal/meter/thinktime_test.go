@@ -0,0 +1,64 @@
+package meter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestThinkTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cr, err := runFixture(t, "think.json", srv.URL)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+
+	// a routine starts its second iteration 100ms after the first, and think time of
+	// browse is not counted in latency
+	sr := cr.Schedules[0]
+	if du := sr.End.Sub(sr.Start); du < 100*time.Millisecond {
+		t.Errorf("expect iterations paced, schedule ends in %v", du)
+	}
+	if st := sr.Tests[0].PerfStat; st.Requests != 3 || st.Max >= 30000 {
+		t.Errorf("expect browse latency without think time, got %d requests max %dus", st.Requests, st.Max)
+	}
+	if du := cr.Schedules[1].End.Sub(cr.Schedules[1].Start); du < 80*time.Millisecond {
+		t.Errorf("expect think time between iterations, schedule ends in %v", du)
+	}
+	if du := cr.Schedules[2].End.Sub(cr.Schedules[2].Start); du > time.Second {
+		t.Errorf("expect think time ends with duration, schedule ends in %v", du)
+	}
+
+	for _, tt := range []*config.ThinkTime{
+		{Duration: "10ms"},
+		{Model: config.ThinkUniform, Min: "10ms", Max: "20ms"},
+		{Model: config.ThinkExponential, Duration: "10ms", Max: "15ms"},
+	} {
+		th, err := makeThinkTime(tt)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		for i := 0; i < 100; i++ {
+			d := th.delay()
+			if d < th.min || (th.max > 0 && d > th.max) || (th.max == 0 && d != th.du) {
+				t.Errorf("think time %+v delay %v out of range", tt, d)
+				break
+			}
+		}
+	}
+	for _, tt := range []*config.ThinkTime{
+		{},
+		{Model: "normal", Duration: "1s"},
+		{Model: config.ThinkUniform, Min: "2s", Max: "1s"},
+		{Duration: "-1s"},
+	} {
+		if err = tt.Check(); err == nil {
+			t.Errorf("expect think time %+v invalid", tt)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package meter
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reqTrace records time of each phase of an HTTP request, a phase takes zero
+// if it does not happen, for example, a reused connection needs no DNS, connect
+// or TLS.
+type reqTrace struct {
+	start                         time.Time
+	dnsStart, connStart, tlsStart time.Time
+	dns, connect, tls, ttfb       time.Duration
+	gotConn, reused               bool
+	mtx                           sync.Mutex
+}
+
+// attach starts tracing and returns request with trace context.
+func (t *reqTrace) attach(req *http.Request) *http.Request {
+	t.start = time.Now()
+	ct := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mtx.Lock()
+			t.dnsStart = time.Now()
+			t.mtx.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mtx.Lock()
+			t.dns = time.Since(t.dnsStart)
+			t.mtx.Unlock()
+		},
+		ConnectStart: func(_, _ string) {
+			t.mtx.Lock()
+			if t.connStart.IsZero() {
+				t.connStart = time.Now()
+			}
+			t.mtx.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mtx.Lock()
+			if err == nil {
+				t.connect = time.Since(t.connStart)
+			}
+			t.mtx.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mtx.Lock()
+			t.tlsStart = time.Now()
+			t.mtx.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mtx.Lock()
+			t.tls = time.Since(t.tlsStart)
+			t.mtx.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mtx.Lock()
+			t.gotConn = true
+			t.reused = info.Reused
+			t.mtx.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mtx.Lock()
+			t.ttfb = time.Since(t.start)
+			t.mtx.Unlock()
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), ct))
+}
+
+// conn returns if a connection is got and if it is reused
+func (t *reqTrace) conn() (bool, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.gotConn, t.reused
+}
+
+func us(d time.Duration) string {
+	return strconv.FormatInt(d.Microseconds(), 10)
+}
+
+// setEnv writes timings in microseconds into local variables, total is the time
+// from request start to response body read.
+func (t *reqTrace) setEnv(bg *background) {
+	total := time.Since(t.start)
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	bg.setLocalEnv(KeyTimeDNS, us(t.dns))
+	bg.setLocalEnv(KeyTimeConnect, us(t.connect))
+	bg.setLocalEnv(KeyTimeTLS, us(t.tls))
+	bg.setLocalEnv(KeyTimeTTFB, us(t.ttfb))
+	bg.setLocalEnv(KeyTimeTotal, us(total))
+	bg.setLocalEnv(KeyConnReused, strconv.FormatBool(t.reused))
+}
+
+// headerKey is the local variable name of response header, name is canonicalized
+// so that header could be referenced case-insensitively.
+func headerKey(name string) string {
+	return KeyHeader + textproto.CanonicalMIMEHeaderKey(name)
+}
+
+// setResponseEnv writes response headers, protocol, body length and redirect
+// chain into local variables, those of previous response are removed. Multiple
+// values of a header are joined by ", ".
+func setResponseEnv(bg *background, rsp *http.Response, length int) {
+	clearResponseEnv(bg)
+	set := func(k, v string) {
+		bg.setLocalEnv(k, v)
+		bg.rspEnv = append(bg.rspEnv, k)
+	}
+	for k, v := range rsp.Header {
+		set(headerKey(k), strings.Join(v, ", "))
+	}
+	bg.setLocalEnv(KeyProto, rsp.Proto)
+	bg.setLocalEnv(KeyContentLength, strconv.Itoa(length))
+
+	// each redirected request holds the response causing the redirect
+	var chain []*http.Response
+	for r := rsp; r != nil; {
+		chain = append(chain, r)
+		if r.Request == nil {
+			break
+		}
+		r = r.Request.Response
+	}
+	bg.setLocalEnv(KeyRedirects, strconv.Itoa(len(chain)-1))
+	for i := range chain {
+		r := chain[len(chain)-1-i]
+		prefix := KeyRedirect + strconv.Itoa(i) + "."
+		if r.Request != nil && r.Request.URL != nil {
+			set(prefix+"URL", r.Request.URL.String())
+		}
+		set(prefix+"STATUS", strconv.Itoa(r.StatusCode))
+	}
+}
+
+// clearResponseEnv removes variables of previous response.
+func clearResponseEnv(bg *background) {
+	for _, k := range bg.rspEnv {
+		bg.delLocalEnv(k)
+	}
+	bg.rspEnv = nil
+}
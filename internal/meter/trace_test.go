@@ -0,0 +1,71 @@
+package meter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestResponseEnv(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/headers" {
+			w.Header().Add("X-Multi", "a")
+			w.Header().Add("X-Multi", "b")
+			w.Header().Set("ETag", `"v1"`)
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	cr, err := runFixture(t, "response.json", srv.URL)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	expect := map[string]string{
+		"multi":  "a, b",
+		"etag":   `"v1"`,
+		"proto":  "HTTP/1.1",
+		"length": "5",
+		"tls":    "0",
+		"stale":  "false",
+	}
+	for k, v := range expect {
+		if cr.DB[k] != v {
+			t.Errorf("%s expect %s get %s", k, v, cr.DB[k])
+		}
+	}
+	ttfb, err := strconv.Atoi(cr.DB["ttfb"])
+	if err != nil || ttfb <= 0 {
+		t.Errorf("invalid ttfb %s", cr.DB["ttfb"])
+	}
+	total, err := strconv.Atoi(cr.DB["total"])
+	if err != nil || total < ttfb {
+		t.Errorf("invalid total %s", cr.DB["total"])
+	}
+}
+
+func TestResponseEnvThrottle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	// requests are paced 250ms apart, the last one waits for its turn
+	cfg := loadFixture(t, "response.json", srv.URL)
+	cfg.Schedules[0].Tests = "headers"
+	cfg.Schedules[0].Count = 3
+	cfg.Schedules[0].QPS = 4
+	cr, err := runConfig(cfg)
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	// timings including the wait would be close to the interval
+	interval := 250000
+	for _, k := range []string{"ttfb", "total"} {
+		v, err := strconv.Atoi(cr.DB[k])
+		if err != nil || v >= interval/2 {
+			t.Errorf("expect %s less than pacing interval, got %s", k, cr.DB[k])
+		}
+	}
+}
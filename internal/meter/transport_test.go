@@ -0,0 +1,113 @@
+package meter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestTransport(t *testing.T) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	cases := []struct {
+		name        string
+		transport   func(tr *config.Transport)
+		concurrency int
+		count       uint64
+		conns       int64 // connections server accepts
+		reused      string
+	}{
+		{"keep-alive", func(tr *config.Transport) {}, 1, 6, 1, "true"},
+		{"disable-keep-alive", func(tr *config.Transport) { tr.DisableKeepAlive = true }, 1, 6, 6, "false"},
+		{"new-connection", func(tr *config.Transport) { tr.NewConnection = true }, 1, 6, 6, "false"},
+		{"per-routine", func(tr *config.Transport) { tr.PerRoutine = true }, 3, 60, 3, "true"},
+	}
+	for _, c := range cases {
+		cfg := loadFixture(t, "transport.json", srv.URL)
+		c.transport(cfg.Hosts["-"].Transport)
+		cfg.Schedules[0].Concurrency = c.concurrency
+		cfg.Schedules[0].Count = c.count
+
+		atomic.StoreInt64(&conns, 0)
+		cr, err := runConfig(cfg)
+		if err != nil {
+			t.Fatalf("%s: run config: %v", c.name, err)
+		}
+		if n := atomic.LoadInt64(&conns); n != c.conns {
+			t.Errorf("%s: expect %d connections, get %d", c.name, c.conns, n)
+		}
+		if cr.DB["reused"] != c.reused {
+			t.Errorf("%s: expect reused %s, get %s", c.name, c.reused, cr.DB["reused"])
+		}
+		st := cr.Schedules[0].PerfStat
+		if st.Connections != c.conns || st.Connections+st.Reused != int64(c.count) {
+			t.Errorf("%s: unexpected connections %d reused %d", c.name, st.Connections, st.Reused)
+		}
+	}
+}
+
+func TestTransportHTTP2(t *testing.T) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.EnableHTTP2 = true
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, newConn := range []bool{false, true} {
+		cfg := loadFixture(t, "transport.json", srv.URL)
+		cfg.Hosts["-"].TLS = &config.TLS{InsecureSkipVerify: true}
+		cfg.Hosts["-"].Transport.NewConnection = newConn
+		cfg.Tests["ping"].Response.Success = "`db -w proto $(PROTO)`"
+
+		atomic.StoreInt64(&conns, 0)
+		cr, err := runConfig(cfg)
+		if err != nil {
+			t.Fatalf("run config: %v", err)
+		}
+		// HTTP/2 is not used for a new connection per request
+		conn, proto := int64(1), "HTTP/2.0"
+		if newConn {
+			conn, proto = 6, "HTTP/1.1"
+		}
+		if n := atomic.LoadInt64(&conns); n != conn || cr.DB["proto"] != proto {
+			t.Errorf("new connection %v: expect %d connections of %s, get %d of %s",
+				newConn, conn, proto, n, cr.DB["proto"])
+		}
+	}
+}
+
+func TestTLSKey(t *testing.T) {
+	abs, err := filepath.Abs(fixtureDir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	k1 := tlsKey(&config.TLS{CA: "ca.pem", Cert: "cert.pem", Key: "key.pem"}, fixtureDir)
+	k2 := tlsKey(&config.TLS{CA: abs + "/ca.pem", Cert: "cert.pem", Key: "key.pem"}, abs)
+	if k1 != k2 {
+		t.Fatalf("expect same key of same files, get %s and %s", k1, k2)
+	}
+	if k3 := tlsKey(&config.TLS{CA: "ca.pem", Cert: "cert.pem", Key: "key.pem"}, t.TempDir()); k3 == k1 {
+		t.Fatalf("expect different key of different files")
+	}
+}
@@ -0,0 +1,245 @@
+package meter
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/forrestjgq/glog"
+	"github.com/forrestjgq/gomark"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+// wsExchange sends a message and waits for a reply.
+type wsExchange struct {
+	send     segments
+	binary   bool
+	noReply  bool
+	check    composable
+	template jsonRule
+}
+
+// wsRunner runs a WebSocket test, it shares provider, consumer, flow control
+// and perf with HTTP runner, while its HTTP client is not used.
+type wsRunner struct {
+	*runner
+	dialer    *websocket.Dialer
+	timeout   time.Duration
+	exchanges []*wsExchange
+}
+
+// wsHost converts http or https host to ws or wss.
+func wsHost(host string) string {
+	if strings.HasPrefix(host, "http") {
+		return "ws" + strings.TrimPrefix(host, "http")
+	}
+	return host
+}
+
+func makeWsRunner(r *runner, t *config.Test, s *config.Schedule, cfg *config.Config) (*wsRunner, error) {
+	ws := t.WebSocket
+	if err := ws.Check(); err != nil {
+		return nil, err
+	}
+	h, err := loadHost(t, s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.Backends) > 0 {
+		return nil, errors.Errorf("host %s backends are not supported by WebSocket", t.Host)
+	}
+	timeout, err := time.ParseDuration(t.Timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse timeout %s", t.Timeout)
+	}
+	tc, err := loadTLSConfig(h.TLS, cfg.Options[config.OptionCfgPath])
+	if err != nil {
+		return nil, errors.Wrapf(err, "host %s load TLS", t.Host)
+	}
+	dial, err := makeDialer(h, cfg.Options[config.OptionCfgPath])
+	if err != nil {
+		return nil, errors.Wrapf(err, "host %s dialer", t.Host)
+	}
+
+	w := &wsRunner{
+		runner: r,
+		dialer: &websocket.Dialer{
+			HandshakeTimeout: timeout,
+			TLSClientConfig:  tc,
+			NetDialContext:   dial,
+		},
+		timeout: timeout,
+	}
+	if len(h.Proxy) > 0 {
+		proxy, err := url.Parse(h.Proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse proxy %s", h.Proxy)
+		}
+		w.dialer.Proxy = http.ProxyURL(proxy)
+	}
+
+	for i, e := range ws.Exchanges {
+		x := &wsExchange{
+			binary:  e.Binary,
+			noReply: e.NoReply,
+		}
+		if x.send, err = makeSegments(e.Send); err != nil {
+			return nil, errors.Wrapf(err, "exchange %d send", i)
+		}
+		if x.check, _, err = makeComposable(e.Check); err != nil {
+			return nil, errors.Wrapf(err, "exchange %d check", i)
+		}
+		if x.template, err = makeJsonTemplate(e.Template); err != nil {
+			return nil, errors.Wrapf(err, "exchange %d template", i)
+		}
+		w.exchanges = append(w.exchanges, x)
+	}
+	return w, nil
+}
+
+// fail counts failure err of class and processes it.
+func (w *wsRunner) fail(bg *background, class string, err error) next {
+	bg.failClass = class
+	w.record(bg, 0, err)
+	return w.c.processFailure(bg, err)
+}
+
+func (w *wsRunner) run(bg *background) next {
+	var (
+		addr     string
+		headers  map[string]string
+		decision next
+		p        provider
+	)
+
+	if w.provSrc == nil || w.c == nil || bg == nil {
+		glog.Error("invalid WebSocket runner")
+		return nextAbortAll
+	}
+	bg.setLocalEnv(KeyTest, w.name)
+
+	if p, decision = w.provSrc.getProvider(bg); decision != nextContinue {
+		return decision
+	}
+	if addr, decision = p.getUrl(bg); decision != nextContinue {
+		return decision
+	}
+	if headers, decision = p.getHeaders(bg); decision != nextContinue {
+		return decision
+	}
+	bg.setLocalEnv(KeyURL, addr)
+
+	hdr := make(http.Header)
+	for k, v := range headers {
+		hdr.Add(k, v)
+	}
+
+	if bg.fc != nil {
+		pp, ok := bg.fc.wait(bg.quit)
+		if !ok {
+			return nextFinished
+		}
+		defer pp.cancel()
+	}
+	if w.fc != nil {
+		pp, ok := w.fc.wait(bg.quit)
+		if !ok {
+			return nextFinished
+		}
+		defer pp.cancel()
+	}
+
+	conn, rsp, err := w.dialer.Dial(addr, hdr)
+	if rsp != nil {
+		bg.setLocalEnv(KeyStatus, strconv.Itoa(rsp.StatusCode))
+	}
+	if err != nil {
+		return w.fail(bg, classifyError(err), errors.Wrap(err, "WebSocket handshake"))
+	}
+	defer conn.Close()
+
+	// each reply is counted as a request, it is counted when next exchange waits
+	// for a reply or fails, and the last one is counted after response is
+	// processed. The test is counted once if it waits for no reply.
+	pending, replied := false, false
+	flush := func() {
+		if pending {
+			w.record(bg, 0, nil)
+			pending = false
+		}
+	}
+	fail := func(class string, err error) next {
+		flush()
+		return w.fail(bg, class, err)
+	}
+	for i, e := range w.exchanges {
+		if !e.noReply {
+			flush()
+		}
+		bg.setLocalEnv(KeyExchange, strconv.Itoa(i+1))
+		msg, err := e.send.compose(bg)
+		if err != nil {
+			return fail(failRequest, errors.Wrapf(err, "compose exchange %d", i+1))
+		}
+		bg.setLocalEnv(KeyRequest, msg)
+
+		var latency *gomark.Latency
+		if bg.perf != nil {
+			latency = gomark.NewLatency(bg.perf.lr)
+		}
+		if len(msg) > 0 {
+			typ := websocket.TextMessage
+			if e.binary {
+				typ = websocket.BinaryMessage
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(w.timeout))
+			if err = conn.WriteMessage(typ, []byte(msg)); err != nil {
+				return fail(classifyError(err), errors.Wrapf(err, "send exchange %d", i+1))
+			}
+		}
+		if e.noReply {
+			continue
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(w.timeout))
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return fail(classifyError(err), errors.Wrapf(err, "receive exchange %d", i+1))
+		}
+		w.mark(bg, latency)
+		bg.setLocalEnv(KeyResponse, string(b))
+
+		if e.template != nil {
+			if err = compareTemplate(e.template, bg, string(b)); err != nil {
+				flush()
+				bg.failClass = failTemplate
+				decision = w.c.processFailure(bg, errors.Wrapf(err, "exchange %d", i+1))
+				w.record(bg, 0, nil)
+				return decision
+			}
+		}
+		if e.check != nil {
+			if _, err = e.check.compose(bg); err != nil {
+				flush()
+				bg.failClass = failCheck
+				decision = w.c.processFailure(bg, errors.Wrapf(err, "exchange %d", i+1))
+				w.record(bg, 0, nil)
+				return decision
+			}
+		}
+		pending, replied = true, true
+	}
+
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	decision = w.c.processResponse(bg)
+	if pending || !replied {
+		w.record(bg, 0, nil)
+	}
+	return decision
+}
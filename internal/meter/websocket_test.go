@@ -0,0 +1,67 @@
+package meter
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/forrestjgq/gmeter/config"
+)
+
+func TestWebSocket(t *testing.T) {
+	c := &config.HttpServers{
+		Servers: map[string]*config.HttpServer{
+			"websocket": {
+				Address: "127.0.0.1:0",
+				Routes: []*config.Route{
+					{
+						Path:      "/echo",
+						WebSocket: true,
+						Request: &config.RequestProcess{
+							Check: "`assert $(@strlen $(REQUEST)) < 20`",
+						},
+						Response: map[string]json.RawMessage{
+							"echo": json.RawMessage(`{"echo": "$(REQUEST)"}`),
+						},
+					},
+				},
+			},
+		},
+	}
+	err := StartHTTPServerConfig(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer StopAll()
+
+	cr, err := runFixture(t, "websocket.json", "http://127.0.0.1:"+strconv.Itoa(servers["websocket"].port))
+	if err != nil {
+		t.Fatalf("run config: %v", err)
+	}
+	if v := cr.DB["chat"]; v != "3" {
+		t.Errorf("expect all exchanges done, got %s", v)
+	}
+	if v := cr.DB["bad"]; !strings.Contains(v, "receive exchange 1") {
+		t.Errorf("expect bad message rejected, got %s", v)
+	}
+
+	// each reply is counted as a request
+	res := cr.Schedules[0].Tests[0]
+	if res.PerfStat.Requests != 4 || res.Failed != 0 {
+		t.Errorf("expect 4 successful exchanges, got %d requests %d failed", res.PerfStat.Requests, res.Failed)
+	}
+	if res = cr.Schedules[1].Tests[0]; res.Failed != 1 {
+		t.Errorf("expect bad exchange failed, got %d failed", res.Failed)
+	}
+
+	for _, ws := range []*config.WebSocket{
+		{},
+		{Exchanges: []*config.Exchange{{NoReply: true}}},
+		{Exchanges: []*config.Exchange{{Send: "a", NoReply: true, Check: "`nop`"}}},
+	} {
+		if err = ws.Check(); err == nil {
+			t.Errorf("expect WebSocket %+v invalid", ws)
+		}
+	}
+}